@@ -20,7 +20,9 @@ package main
 import (
 	// Standard
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	// 3rd Party
 	"github.com/fatih/color"
@@ -31,6 +33,7 @@ import (
 	"github.com/Ne0nd0g/merlin/pkg/cli/banner"
 	"github.com/Ne0nd0g/merlin/pkg/logging"
 	"github.com/Ne0nd0g/merlin/pkg/pwnboard"
+	"github.com/Ne0nd0g/merlin/pkg/server/jobs"
 )
 
 // Global Variables
@@ -63,6 +66,34 @@ func main() {
 		go pwnboard.Updateserver(*ip)
 	}
 
+	// Restore jobs from a previous run, then periodically expire stale jobs and save them to disk
+	err := jobs.LoadJobs(jobs.PersistFile)
+	if err != nil {
+		color.Red(fmt.Sprintf("[!]%s", err))
+		logging.Server(err.Error())
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			expired := jobs.ExpireJobs()
+			if expired > 0 {
+				logging.Server(fmt.Sprintf("%d jobs expired", expired))
+			}
+			if timedOut := jobs.Expire(); timedOut > 0 {
+				logging.Server(fmt.Sprintf("%d jobs timed out", timedOut))
+			}
+			if retention := jobs.Retention(); retention > 0 {
+				pruned := jobs.Prune(retention)
+				if pruned > 0 {
+					logging.Server(fmt.Sprintf("%d jobs older than %s were pruned", pruned, retention))
+				}
+			}
+			errSave := jobs.SaveJobs(jobs.PersistFile)
+			if errSave != nil {
+				logging.Server(errSave.Error())
+			}
+		}
+	}()
+
 	// Start Merlin Command Line Interface
 	cli.Shell()
 }
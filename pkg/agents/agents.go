@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	// 3rd Party
@@ -47,6 +48,12 @@ var Agents = make(map[uuid.UUID]*Agent)
 // groups map agent(s) to a string for bulk access
 var groups = make(map[string][]uuid.UUID)
 
+// checkInHistorySize is the number of recent check in times kept for each agent
+var checkInHistorySize = 50
+
+// checkInMutex guards the check in history size and every agent's check in history
+var checkInMutex = &sync.Mutex{}
+
 func init() {
 	globalUUID, err := uuid.FromString("ffffffff-ffff-ffff-ffff-ffffffffffff")
 	if err == nil {
@@ -68,6 +75,7 @@ type Agent struct {
 	agentLog       *os.File
 	InitialCheckIn time.Time
 	StatusCheckIn  time.Time
+	LastResult     time.Time // The last time the agent returned the results of a job
 	Version        string
 	Build          string
 	WaitTime       string
@@ -83,6 +91,8 @@ type Agent struct {
 	OPAQUE         *opaque.Server  // Holds information about OPAQUE Registration and Authentication
 	JA3            string          // The JA3 signature applied to the agent's TLS client
 	Note           string          // Operator notes for an agent
+	Tags           []string        // Operator labels for an agent
+	checkIns       []time.Time     // The agent's most recent check in times, oldest first
 }
 
 // KeyExchange is used to exchange public keys between the server and agent
@@ -335,7 +345,7 @@ func New(agentID uuid.UUID) (Agent, error) {
 	agent.ID = agentID
 	agent.agentLog = f
 	agent.InitialCheckIn = time.Now().UTC()
-	agent.StatusCheckIn = time.Now().UTC()
+	agent.CheckIn()
 
 	_, errAgentLog := agent.agentLog.WriteString(fmt.Sprintf("[%s]%s\r\n", time.Now().UTC().Format(time.RFC3339), "Instantiated agent"))
 	if errAgentLog != nil {
@@ -400,6 +410,45 @@ func GetLifetime(agentID uuid.UUID) (time.Duration, error) {
 
 }
 
+// CheckIn updates the time the agent last checked in and adds it to the agent's check in history, dropping the oldest
+// check in once the history is full
+func (a *Agent) CheckIn() {
+	now := time.Now().UTC()
+	a.StatusCheckIn = now
+	checkInMutex.Lock()
+	a.checkIns = append(a.checkIns, now)
+	if len(a.checkIns) > checkInHistorySize {
+		a.checkIns = append([]time.Time(nil), a.checkIns[len(a.checkIns)-checkInHistorySize:]...)
+	}
+	checkInMutex.Unlock()
+}
+
+// GetCheckInHistory returns the agent's most recent check in times, oldest first and newest last
+func GetCheckInHistory(agentID uuid.UUID) ([]time.Time, error) {
+	agent, ok := Agents[agentID]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a known agent", agentID)
+	}
+	checkInMutex.Lock()
+	defer checkInMutex.Unlock()
+	history := agent.checkIns
+	if len(history) > checkInHistorySize {
+		history = history[len(history)-checkInHistorySize:]
+	}
+	return append([]time.Time(nil), history...), nil
+}
+
+// SetCheckInHistorySize sets the number of recent check in times kept for each agent
+func SetCheckInHistorySize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("the check in history size must be greater than 0: %d", size)
+	}
+	checkInMutex.Lock()
+	checkInHistorySize = size
+	checkInMutex.Unlock()
+	return nil
+}
+
 // SetWaitTime updates an Agent's sleep amount or Wait Time
 func SetWaitTime(agentID uuid.UUID, wait string) error {
 	if isAgent(agentID) {
@@ -435,6 +484,41 @@ func SetAgentNote(agentID uuid.UUID, note string) error {
 	return nil
 }
 
+// AddAgentTag adds a label to the agent if it doesn't already have it
+func AddAgentTag(agentID uuid.UUID, tag string) error {
+	if !isAgent(agentID) {
+		return fmt.Errorf("%s is not a known agent", agentID)
+	}
+	if tag == "" {
+		return fmt.Errorf("a tag can not be empty")
+	}
+	for _, t := range Agents[agentID].Tags {
+		if t == tag {
+			return nil
+		}
+	}
+	Agents[agentID].Tags = append(Agents[agentID].Tags, tag)
+	return nil
+}
+
+// RemoveAgentTag removes a label from the agent
+func RemoveAgentTag(agentID uuid.UUID, tag string) error {
+	if !isAgent(agentID) {
+		return fmt.Errorf("%s is not a known agent", agentID)
+	}
+	var tags []string
+	for _, t := range Agents[agentID].Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) == len(Agents[agentID].Tags) {
+		return fmt.Errorf("agent %s does not have the tag %s", agentID, tag)
+	}
+	Agents[agentID].Tags = tags
+	return nil
+}
+
 // GroupAddAgent adds an agent to a group
 func GroupAddAgent(agentID uuid.UUID, groupName string) error {
 	if !isAgent(agentID) {
@@ -466,6 +550,17 @@ func GroupListAll() [][]string {
 	return out
 }
 
+// GroupMembers returns a copy of the list of agents that belong to a group
+func GroupMembers(groupName string) ([]uuid.UUID, error) {
+	grp, ok := groups[groupName]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a group", groupName)
+	}
+	members := make([]uuid.UUID, len(grp))
+	copy(members, grp)
+	return members, nil
+}
+
 // GroupListNames list out just the names of existing groups
 func GroupListNames() []string {
 	keys := make([]string, 0, len(groups))
@@ -21,10 +21,13 @@ import (
 	// Standard
 	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	// 3rd Party
@@ -41,6 +44,18 @@ import (
 	"github.com/Ne0nd0g/merlin/pkg/server/jobs"
 )
 
+// jsonFile is the file job results are written to in JSON output mode
+var jsonFile *os.File
+
+// delayedMultiplier is the number of wait times an agent can go without checking in before it is Delayed
+var delayedMultiplier = 1.0
+
+// deadMultiplier is the number of times an agent can use all of its retries before it is Dead
+var deadMultiplier = 1.0
+
+// thresholdMutex guards the Delayed and Dead multipliers
+var thresholdMutex = &sync.RWMutex{}
+
 // CD is used to change the agent's current working directory
 func CD(agentID uuid.UUID, Args []string) messages.UserMessage {
 	var args []string
@@ -49,7 +64,7 @@ func CD(agentID uuid.UUID, Args []string) messages.UserMessage {
 	} else {
 		return messages.ErrorMessage("a directory path must be provided")
 	}
-	job, err := jobs.Add(agentID, "cd", args)
+	job, err := addJob(agentID, "cd", args)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -70,6 +85,20 @@ func ClearJobs(agentID uuid.UUID) messages.UserMessage {
 	}
 }
 
+// CancelJob removes a single unsent job from the agent's queue
+func CancelJob(agentID uuid.UUID, jobID string) messages.UserMessage {
+	err := jobs.Cancel(agentID, jobID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("job %s canceled for agent %s at %s", jobID, agentID, time.Now().UTC().Format(time.RFC3339)),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
 // ClearJobsCreated clears all created (but unsent) jobs for all agents
 func ClearJobsCreated() messages.UserMessage {
 	err := jobs.ClearCreated()
@@ -84,13 +113,27 @@ func ClearJobsCreated() messages.UserMessage {
 	}
 }
 
+// ClearAllJobs cancels every unsent job for every agent and reports the number of jobs canceled
+func ClearAllJobs() messages.UserMessage {
+	count, err := jobs.ClearAll()
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("%s\r\n%d jobs were canceled before the error", err, count))
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("%d unsent jobs cleared across all agents at %s", count, time.Now().UTC().Format(time.RFC3339)),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
 // CMD is used to send a command to the agent to run a command or execute a program
 // Args[0] = "cmd"
 // Args[1:] = program and arguments to be executed on the host OS of the running agent
 // Used with `cmd` and `shell` commands as well as through "standard" modules
 func CMD(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
-		job, err := jobs.Add(agentID, Args[0], Args[1:])
+		job, err := addJob(agentID, Args[0], Args[1:])
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -104,7 +147,7 @@ func CMD(agentID uuid.UUID, Args []string) messages.UserMessage {
 // Args[1] = file path to download
 func Download(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) >= 2 {
-		job, err := jobs.Add(agentID, "download", []string{Args[1]})
+		job, err := addJob(agentID, "download", Args[1:])
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -113,19 +156,38 @@ func Download(agentID uuid.UUID, Args []string) messages.UserMessage {
 	return messages.ErrorMessage(fmt.Sprintf("not enough arguments provided for the Agent Download call: %s", Args))
 }
 
+// DownloadDir is used to download every file in a directory on the agent, recreating the directory on the server
+func DownloadDir(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) >= 2 {
+		job, err := addJob(agentID, "downloadDir", Args[1:])
+		if err != nil {
+			return messages.ErrorMessage(err.Error())
+		}
+		return messages.JobMessage(agentID, job)
+	}
+	return messages.ErrorMessage(fmt.Sprintf("not enough arguments provided for the Agent DownloadDir call: %s", Args))
+}
+
 // ENV is used to view or modify a host's environment variables
 func ENV(agentID uuid.UUID, Args []string) messages.UserMessage {
 	var job string
 	var err error
 	if len(Args) > 1 {
 		switch strings.ToLower(Args[1]) {
-		case "get", "set", "unset":
-			if len(Args) < 2 {
-				return messages.ErrorMessage(fmt.Sprintf("Not enough arguments for the env %s command.\nenv %s <environment variable>", Args[0], Args[1]))
+		case "get", "unset":
+			if len(Args) < 3 {
+				return messages.ErrorMessage(fmt.Sprintf("Not enough arguments for the env %s command.\nenv %s <environment variable>", Args[1], Args[1]))
+			}
+			job, err = addJob(agentID, "env", Args[1:3])
+		case "set":
+			if len(Args) < 4 {
+				return messages.ErrorMessage("Not enough arguments for the env set command.\nenv set <environment variable> <value>")
 			}
-			job, err = jobs.Add(agentID, "env", Args[1:])
+			job, err = addJob(agentID, "env", Args[1:4])
 		case "showall":
-			job, err = jobs.Add(agentID, "env", Args[1:2])
+			job, err = addJob(agentID, "env", Args[1:2])
+		default:
+			return messages.ErrorMessage(fmt.Sprintf("Invalid env command: %s\nenv <get | set | unset | showall> [variable] [value]", Args[1]))
 		}
 	} else {
 		return messages.ErrorMessage("Not enough arguments for the env command.")
@@ -136,6 +198,42 @@ func ENV(agentID uuid.UUID, Args []string) messages.UserMessage {
 	return messages.JobMessage(agentID, job)
 }
 
+// Copy copies a file on the Agent's host from the source to the destination
+func Copy(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 3 {
+		return messages.ErrorMessage("not enough arguments provided, a source and destination file must be provided")
+	}
+	job, err := addJob(agentID, "cp", Args[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// Delete deletes one or more files on the Agent's host. Directories are only deleted with both the -r and --force flags
+func Delete(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("not enough arguments provided, a file or directory must be provided")
+	}
+	job, err := addJob(agentID, "rm", Args[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// Move moves, or renames, a file on the Agent's host from the source to the destination
+func Move(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 3 {
+		return messages.ErrorMessage("not enough arguments provided, a source and destination file must be provided")
+	}
+	job, err := addJob(agentID, "mv", Args[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
 // ExecuteAssembly calls the donut module to create shellcode from a .NET 4.0 assembly and then uses the CreateProcess
 // module to create a job that executes the shellcode in a remote process
 func ExecuteAssembly(agentID uuid.UUID, Args []string) messages.UserMessage {
@@ -192,7 +290,25 @@ func ExecuteAssembly(agentID uuid.UUID, Args []string) messages.UserMessage {
 	}
 
 	// Add job to the Agent's queue
-	job, err := jobs.Add(agentID, j[0], j[1:])
+	job, err := addJob(agentID, j[0], j[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// ExecuteBOF reads a Beacon Object File (BOF) from the server and executes it in the Agent's process with the packed
+// arguments, each as <type>:<value>
+func ExecuteBOF(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("the BOF file path was not provided for execute-bof")
+	}
+	bof, err := ioutil.ReadFile(Args[1])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error reading the BOF %s:\r\n%s", Args[1], err))
+	}
+
+	job, err := addJob(agentID, "bof", append([]string{base64.StdEncoding.EncodeToString(bof)}, Args[2:]...))
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -252,7 +368,7 @@ func ExecutePE(agentID uuid.UUID, Args []string) messages.UserMessage {
 	}
 
 	// Add job to the Agent's queue
-	job, err := jobs.Add(agentID, j[0], j[1:])
+	job, err := addJob(agentID, j[0], j[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -270,6 +386,19 @@ func ExecuteShellcode(agentID uuid.UUID, Args []string) messages.UserMessage {
 			options["method"] = "self"
 			options["pid"] = ""
 			options["shellcode"] = strings.Join(Args[2:], " ")
+		case "memfd":
+			// Space separated hex shellcode can start with what looks like a PID, so a PID was only provided when the
+			// arguments aren't shellcode but are once a leading integer is removed
+			if _, err := shellcode.ParseShellcode(strings.Join(Args[2:], " ")); err != nil && len(Args) > 3 {
+				if _, errPID := strconv.Atoi(Args[2]); errPID == nil {
+					if _, errSh := shellcode.ParseShellcode(strings.Join(Args[3:], " ")); errSh == nil {
+						return messages.ErrorMessage("the memfd shellcode method does not accept a PID")
+					}
+				}
+			}
+			options["method"] = "memfd"
+			options["pid"] = ""
+			options["shellcode"] = strings.Join(Args[2:], " ")
 		case "remote":
 			if len(Args) > 3 {
 				options["method"] = "remote"
@@ -303,7 +432,7 @@ func ExecuteShellcode(agentID uuid.UUID, Args []string) messages.UserMessage {
 				m := fmt.Sprintf("there was an error parsing the shellcode:\r\n%s", errSh.Error())
 				return messages.ErrorMessage(m)
 			}
-			job, err := jobs.Add(agentID, sh[0], sh[1:])
+			job, err := addJob(agentID, sh[0], sh[1:])
 			if err != nil {
 				return messages.ErrorMessage(err.Error())
 			}
@@ -316,7 +445,7 @@ func ExecuteShellcode(agentID uuid.UUID, Args []string) messages.UserMessage {
 // Exit instructs the agent to quit running
 func Exit(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 0 {
-		job, err := jobs.Add(agentID, "exit", Args[0:])
+		job, err := addJob(agentID, "exit", Args[0:])
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -333,10 +462,57 @@ func GetAgents() (agentList []uuid.UUID) {
 	return
 }
 
+// FindAgents returns the ID of every agent where predicate returns true, sorted so that the order is stable
+func FindAgents(predicate func(agents.Agent) bool) []uuid.UUID {
+	var found []uuid.UUID
+	for id, agent := range agents.Agents {
+		if predicate(*agent) {
+			found = append(found, id)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].String() < found[j].String() })
+	return found
+}
+
+// FindByHostname returns the ID of every agent whose hostname contains substr, ignoring case
+func FindByHostname(substr string) []uuid.UUID {
+	substr = strings.ToLower(substr)
+	return FindAgents(func(agent agents.Agent) bool {
+		return strings.Contains(strings.ToLower(agent.HostName), substr)
+	})
+}
+
+// FindByUser returns the ID of every agent whose username contains substr, ignoring case
+func FindByUser(substr string) []uuid.UUID {
+	substr = strings.ToLower(substr)
+	return FindAgents(func(agent agents.Agent) bool {
+		return strings.Contains(strings.ToLower(agent.UserName), substr)
+	})
+}
+
+// SearchAgentsRows returns the agents table rows for every agent whose hostname or username contains term, ignoring case
+func SearchAgentsRows(term string) (header []string, rows [][]string) {
+	found := make(map[string]bool)
+	for _, id := range append(FindByHostname(term), FindByUser(term)...) {
+		found[id.String()] = true
+	}
+	header, all := GetAgentsRows()
+	for _, row := range all {
+		if found[row[0]] {
+			rows = append(rows, row)
+		}
+	}
+	return header, rows
+}
+
 // GetAgentsRows returns a row of data for every agent that includes information about it such as
 // the Agent's GUID, platform, user, host, transport, and status
 func GetAgentsRows() (header []string, rows [][]string) {
-	header = []string{"Agent GUID", "Transport", "Platform", "Host", "User", "Process", "Status", "Last Checkin", "Note"}
+	header = []string{"Agent GUID", "Transport", "Platform", "Host", "User", "Process", "Status", "Jobs", "Last Checkin", "Tags"}
+	busy := make(map[uuid.UUID]bool)
+	for _, agentID := range jobs.AgentsWithJobs() {
+		busy[agentID] = true
+	}
 	for _, agent := range agents.Agents {
 		// Convert proto (i.e. h2 or hq) to user friendly string
 		var proto string
@@ -367,6 +543,11 @@ func GetAgentsRows() (header []string, rows [][]string) {
 		}
 		p := fmt.Sprintf("%s(%d)", proc, agent.Pid)
 
+		var work string
+		if busy[agent.ID] {
+			work = "Busy"
+		}
+
 		rows = append(rows, []string{
 			agent.ID.String(),
 			proto,
@@ -375,13 +556,23 @@ func GetAgentsRows() (header []string, rows [][]string) {
 			agent.UserName,
 			p,
 			status,
+			work,
 			lastTime,
-			agent.Note,
+			strings.Join(agent.Tags, ", "),
 		})
 	}
 	return
 }
 
+// GetAgentsWithJobs returns the ID of every agent with jobs that are queued, sent, or waiting for more results
+func GetAgentsWithJobs() []string {
+	var agentIDs []string
+	for _, agentID := range jobs.AgentsWithJobs() {
+		agentIDs = append(agentIDs, agentID.String())
+	}
+	return agentIDs
+}
+
 // GetAgentInfo returns rows of data about an Agent's configuration that can be displayed in a table
 func GetAgentInfo(agentID uuid.UUID) ([][]string, messages.UserMessage) {
 	var rows [][]string
@@ -402,6 +593,11 @@ func GetAgentInfo(agentID uuid.UUID) ([][]string, messages.UserMessage) {
 		}
 	}
 
+	lastResult := "Never"
+	if !a.LastResult.IsZero() {
+		lastResult = a.LastResult.Format(time.RFC3339)
+	}
+
 	rows = [][]string{
 		{"Status", status},
 		{"ID", a.ID.String()},
@@ -414,6 +610,7 @@ func GetAgentInfo(agentID uuid.UUID) ([][]string, messages.UserMessage) {
 		{"IP", strings.Join(a.Ips, "\n")},
 		{"Initial Check In", a.InitialCheckIn.Format(time.RFC3339)},
 		{"Last Check In", fmt.Sprintf("%s (%s)", a.StatusCheckIn.Format(time.RFC3339), lastCheckin(a.StatusCheckIn))},
+		{"Last Result", lastResult},
 		{"Groups", strings.Join(groups, ", ")},
 		{"Note", a.Note},
 		{"", ""},
@@ -421,6 +618,7 @@ func GetAgentInfo(agentID uuid.UUID) ([][]string, messages.UserMessage) {
 		{"Agent Build", a.Build},
 		{"Agent Wait Time", a.WaitTime},
 		{"Agent Wait Time Skew", strconv.FormatInt(a.Skew, 10)},
+		{"Expected Check-In Window", checkInWindow(a.WaitTime, a.Skew)},
 		{"Agent Message Padding Max", strconv.Itoa(a.PaddingMax)},
 		{"Agent Max Retries", strconv.Itoa(a.MaxRetry)},
 		{"Agent Failed Check In", strconv.Itoa(a.FailedCheckin)},
@@ -431,6 +629,24 @@ func GetAgentInfo(agentID uuid.UUID) ([][]string, messages.UserMessage) {
 	return rows, messages.UserMessage{}
 }
 
+// checkInWindow returns the range of time expected between the agent's check ins, its wait time plus or minus its skew
+// in milliseconds, or unknown if the wait time can't be parsed
+func checkInWindow(waitTime string, skew int64) string {
+	wait, err := time.ParseDuration(waitTime)
+	if err != nil {
+		return "unknown"
+	}
+	s := time.Duration(skew) * time.Millisecond
+	if s < 0 {
+		s = -s
+	}
+	low := wait - s
+	if low < 0 {
+		low = 0
+	}
+	return fmt.Sprintf("%s to %s (%s ± %s)", low, wait+s, wait, s)
+}
+
 // GetAgentStatus determines if the agent is active, delayed, or dead based on its last checkin time
 func GetAgentStatus(agentID uuid.UUID) (string, messages.UserMessage) {
 	var status string
@@ -442,9 +658,13 @@ func GetAgentStatus(agentID uuid.UUID) (string, messages.UserMessage) {
 	if errDur != nil {
 		return status, messages.ErrorMessage(fmt.Sprintf("Error converting %s to a time duration: %s", agent.WaitTime, errDur))
 	}
-	if agent.StatusCheckIn.Add(dur).After(time.Now()) {
+	thresholdMutex.RLock()
+	delayed := time.Duration(float64(dur) * delayedMultiplier)
+	dead := time.Duration(float64(dur*time.Duration(agent.MaxRetry+1)) * deadMultiplier) // +1 to account for skew
+	thresholdMutex.RUnlock()
+	if agent.StatusCheckIn.Add(delayed).After(time.Now()) {
 		status = "Active"
-	} else if agent.StatusCheckIn.Add(dur * time.Duration(agent.MaxRetry+1)).After(time.Now()) { // +1 to account for skew
+	} else if agent.StatusCheckIn.Add(dead).After(time.Now()) {
 		status = "Delayed"
 	} else {
 		status = "Dead"
@@ -452,9 +672,287 @@ func GetAgentStatus(agentID uuid.UUID) (string, messages.UserMessage) {
 	return status, messages.UserMessage{}
 }
 
+// SetStatusThresholds sets the multipliers used to determine when an agent is Delayed or Dead
+// Args[0] = the number of wait times before an agent is Delayed (default 1)
+// Args[1] = the number of times an agent can use all of its retries before it is Dead (default 1)
+// The Dead multiplier can't be less than the Delayed multiplier so that an agent is never Dead before it is Delayed.
+// They can be equal, as they are by default, because the Dead threshold also grows with the agent's retries
+func SetStatusThresholds(Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("not enough arguments provided, a Delayed and a Dead multiplier must be provided")
+	}
+	delayed, err := strconv.ParseFloat(Args[0], 64)
+	if err != nil || delayed <= 0 {
+		return messages.ErrorMessage(fmt.Sprintf("the Delayed multiplier must be a number greater than 0: %s", Args[0]))
+	}
+	dead, err := strconv.ParseFloat(Args[1], 64)
+	if err != nil || dead <= 0 {
+		return messages.ErrorMessage(fmt.Sprintf("the Dead multiplier must be a number greater than 0: %s", Args[1]))
+	}
+	if dead < delayed {
+		return messages.ErrorMessage(fmt.Sprintf("the Dead multiplier %g must not be less than the Delayed multiplier %g", dead, delayed))
+	}
+	thresholdMutex.Lock()
+	delayedMultiplier = delayed
+	deadMultiplier = dead
+	thresholdMutex.Unlock()
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Agents are Delayed after %g wait times and Dead after %g times their retries", delayed, dead),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GetCheckInHistory returns the agent's most recent check in times, oldest first, so that the regularity of its
+// check ins can be displayed
+func GetCheckInHistory(agentID uuid.UUID) ([]time.Time, messages.UserMessage) {
+	history, err := agents.GetCheckInHistory(agentID)
+	if err != nil {
+		return nil, messages.ErrorMessage(err.Error())
+	}
+	return history, messages.UserMessage{}
+}
+
+// SetCheckInHistorySize sets the number of recent check in times kept for each agent
+func SetCheckInHistorySize(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a number of check ins must be provided")
+	}
+	size, err := strconv.Atoi(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	err = agents.SetCheckInHistorySize(size)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("The last %d check ins will be kept for each agent", size),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
 // GetJobs enumerates all created (but unsent) jobs across all agents
 func GetJobs() [][]string {
-	return jobs.GetTableAll()
+	return jobs.GetTableAllAgents()
+}
+
+// GetJobsForAgentByStatus enumerates the agent's jobs in any of the provided statuses (e.g., sent), or all jobs if
+// no statuses are provided
+func GetJobsForAgentByStatus(agentID uuid.UUID, statuses []string) ([][]string, messages.UserMessage) {
+	var filter []int
+	for _, status := range statuses {
+		s, err := jobs.ParseStatus(status)
+		if err != nil {
+			return nil, messages.ErrorMessage(err.Error())
+		}
+		filter = append(filter, s)
+	}
+	jobsRows, err := jobs.GetTable(agentID, filter...)
+	if err != nil {
+		return nil, messages.ErrorMessage(err.Error())
+	}
+	return jobsRows, messages.UserMessage{}
+}
+
+// Playbook creates, in order, the jobs in a JSON playbook file for the agent
+// Args[0] = playbook
+// Args[1] = the JSON file containing an array of jobs, each with a type and args
+func Playbook(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("not enough arguments provided for the playbook command.\nplaybook <JSON file>")
+	}
+	f, err := os.Open(filepath.Clean(Args[1]))
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error opening the playbook %s:\r\n%s", Args[1], err))
+	}
+	defer f.Close()
+	jobIDs, err := jobs.AddFromJSON(agentID, f)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Created %d jobs for agent %s from playbook %s: %s", len(jobIDs), agentID, Args[1], strings.Join(jobIDs, ", ")),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// ExportJobs writes the agent's active jobs to a JSON playbook file that the playbook command can create again
+func ExportJobs(agentID uuid.UUID, file string) messages.UserMessage {
+	f, err := os.OpenFile(filepath.Clean(file), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error opening %s to export jobs:\r\n%s", file, err))
+	}
+	err = jobs.ExportJSON(agentID, f)
+	if errClose := f.Close(); err == nil && errClose != nil {
+		err = fmt.Errorf("there was an error closing %s:\r\n%s", file, errClose)
+	}
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Exported the active jobs for agent %s to %s", agentID, file),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GetJobEvents returns a table of the most recent job lifecycle events across all agents, oldest first. The optional
+// Args[0] limits the number of events returned
+func GetJobEvents(Args []string) ([]string, [][]string, messages.UserMessage) {
+	var limit int
+	if len(Args) > 0 {
+		var err error
+		limit, err = strconv.Atoi(Args[0])
+		if err != nil || limit < 0 {
+			return nil, nil, messages.ErrorMessage(fmt.Sprintf("invalid event limit %s, expected a positive number", Args[0]))
+		}
+	}
+	var rows [][]string
+	for _, e := range jobs.GetEventLog(limit) {
+		rows = append(rows, []string{e.Time.Format(time.RFC3339), e.AgentID.String(), e.JobID, e.Event, e.Detail})
+	}
+	return []string{"Time", "Agent", "Job", "Event", "Detail"}, rows, messages.UserMessage{}
+}
+
+// GetCompletedJobsForAgent enumerates all completed or canceled jobs for an agent, most recent first
+func GetCompletedJobsForAgent(agentID uuid.UUID) ([][]string, messages.UserMessage) {
+	jobsRows, err := jobs.GetTableCompleted(agentID)
+	if err != nil {
+		return nil, messages.ErrorMessage(err.Error())
+	}
+	return jobsRows, messages.UserMessage{}
+}
+
+// GetJobHistory returns a table of every job for an agent, regardless of its status, oldest first
+func GetJobHistory(agentID uuid.UUID) ([]string, [][]string, messages.UserMessage) {
+	rows, err := jobs.GetTableHistory(agentID)
+	if err != nil {
+		return nil, nil, messages.ErrorMessage(err.Error())
+	}
+	return []string{"ID", "Command", "Status", "Created", "Sent", "Completed", "Exit Code"}, rows, messages.UserMessage{}
+}
+
+// ResendJob creates a new job from an expired, timed out, or canceled job's payload and queues it for the same agent
+func ResendJob(jobID string) messages.UserMessage {
+	newID, err := jobs.Resend(jobID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	j, err := jobs.Status(newID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(j.AgentID, newID)
+}
+
+// ReassignJob moves a job that has not been sent to a different agent as a new job
+func ReassignJob(jobID string, newAgentID uuid.UUID) messages.UserMessage {
+	newID, err := jobs.Reassign(jobID, newAgentID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(newAgentID, newID)
+}
+
+// GetQueueDepth returns the number of jobs that have been created for the agent but not yet sent
+func GetQueueDepth(agentID uuid.UUID) (int, messages.UserMessage) {
+	depth, err := jobs.QueueDepth(agentID)
+	if err != nil {
+		return 0, messages.ErrorMessage(err.Error())
+	}
+	return depth, messages.UserMessage{}
+}
+
+// GetJobCounts returns the number of created, sent, completed, and canceled jobs across all agents. Returned jobs are
+// counted as sent, and expired and timed out jobs as canceled
+func GetJobCounts() (created, sent, complete, canceled int) {
+	return jobs.Count()
+}
+
+// GetDownloadProgress returns how many chunks of a file being downloaded from an agent have been received
+func GetDownloadProgress(jobID string) messages.UserMessage {
+	received, total, err := jobs.DownloadProgress(jobID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	m := fmt.Sprintf("Job %s has received %d of %d chunks", jobID, received, total)
+	if total == 0 {
+		m = fmt.Sprintf("Job %s has not received any chunks", jobID)
+	}
+	return messages.UserMessage{
+		Level:   messages.Info,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GetJobResults returns the stored output of a job so that it can be displayed again
+func GetJobResults(jobID string) messages.UserMessage {
+	result, err := jobs.GetResults(jobID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	if result.Stdout == "" && result.Stderr == "" {
+		return messages.UserMessage{
+			Level:   messages.Note,
+			Message: fmt.Sprintf("Job %s does not have any results", jobID),
+			Time:    time.Now().UTC(),
+			Error:   false,
+		}
+	}
+	m := fmt.Sprintf("Results for job %s", jobID)
+	if result.Stdout != "" {
+		m += fmt.Sprintf("\r\n%s", result.Stdout)
+	}
+	if result.Stderr != "" {
+		m += fmt.Sprintf("\r\nCommand Results (stderr):\r\n%s", result.Stderr)
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GetJobStatus returns a message containing the status and timestamps for a single job
+func GetJobStatus(jobID string) messages.UserMessage {
+	j, err := jobs.Status(jobID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	var zeroTime time.Time
+	var sent, completed string
+	if j.Sent != zeroTime {
+		sent = j.Sent.Format(time.RFC3339)
+	}
+	if j.Completed != zeroTime {
+		completed = j.Completed.Format(time.RFC3339)
+	}
+	m := fmt.Sprintf("Job %s for agent %s\r\n\tType: %s\r\n\tCommand: %s\r\n\tStatus: %s\r\n\tCreated: %s\r\n\tSent: %s\r\n\tCompleted: %s",
+		jobID,
+		j.AgentID,
+		j.Type,
+		j.Command,
+		jobs.StatusString(j.Status),
+		j.Created.Format(time.RFC3339),
+		sent,
+		completed,
+	)
+	return messages.UserMessage{
+		Level:   messages.Info,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
 }
 
 // GetJobsForAgent enumerates all jobs and their status
@@ -466,6 +964,15 @@ func GetJobsForAgent(agentID uuid.UUID) ([][]string, messages.UserMessage) {
 	return jobsRows, messages.UserMessage{}
 }
 
+// GetAllJobsForAgent enumerates every job for the agent, including completed and canceled jobs, and when they completed
+func GetAllJobsForAgent(agentID uuid.UUID) ([][]string, messages.UserMessage) {
+	jobsRows, err := jobs.GetTableAll(agentID)
+	if err != nil {
+		return nil, messages.ErrorMessage(err.Error())
+	}
+	return jobsRows, messages.UserMessage{}
+}
+
 // GroupAdd adds an agent to a server-side grouping
 func GroupAdd(agentID uuid.UUID, groupName string) messages.UserMessage {
 	if groupName == "all" {
@@ -498,9 +1005,45 @@ func GroupList(groupName string) []string {
 	return out
 }
 
-// GroupListAll returns a table of {groupName, agentID}
-func GroupListAll() [][]string {
-	return agents.GroupListAll()
+// GroupJob creates an independent job for every agent in the group
+// Args[0] = the job type (e.g., run)
+// Args[1:] = the job's arguments
+func GroupJob(groupName string, Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a job type must be provided")
+	}
+	jobIDs, err := jobs.AddToGroup(groupName, Args[0], Args[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Note,
+		Message: fmt.Sprintf("Created jobs %s for group %s at %s", strings.Join(jobIDs, ", "), groupName, time.Now().UTC().Format(time.RFC3339)),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// PlatformJob creates a job for every agent running on the platform, such as windows or linux/amd64
+func PlatformJob(platform string, Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a job type must be provided")
+	}
+	jobIDs, err := jobs.AddToPlatform(platform, Args[0], Args[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Note,
+		Message: fmt.Sprintf("Created jobs %s for platform %s at %s", strings.Join(jobIDs, ", "), platform, time.Now().UTC().Format(time.RFC3339)),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GroupListAll returns a table of {groupName, agentID}
+func GroupListAll() [][]string {
+	return agents.GroupListAll()
 }
 
 // GroupListNames returns array of active group names
@@ -530,7 +1073,16 @@ func GroupRemove(agentID uuid.UUID, groupName string) messages.UserMessage {
 
 // IFConfig lists the agent's network adapter information
 func IFConfig(agentID uuid.UUID) messages.UserMessage {
-	job, err := jobs.Add(agentID, "ifconfig", nil)
+	job, err := addJob(agentID, "ifconfig", nil)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// Whoami queries the agent's current user, groups, and privileges and updates the agent's cached user
+func Whoami(agentID uuid.UUID, Args []string) messages.UserMessage {
+	job, err := addJob(agentID, "whoami", nil)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -542,7 +1094,7 @@ func InvokeAssembly(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) < 1 {
 		return messages.ErrorMessage("not enough arguments, the assembly name must be provided")
 	}
-	job, err := jobs.Add(agentID, Args[0], Args[1:])
+	job, err := addJob(agentID, Args[0], Args[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -552,7 +1104,7 @@ func InvokeAssembly(agentID uuid.UUID, Args []string) messages.UserMessage {
 // JA3 is used to change the Agent's JA3 signature
 func JA3(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
-		job, err := jobs.Add(agentID, "ja3", Args)
+		job, err := addJob(agentID, "ja3", Args)
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -564,17 +1116,42 @@ func JA3(agentID uuid.UUID, Args []string) messages.UserMessage {
 // KillDate configures the date and time that the agent will stop running
 func KillDate(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
-		_, errU := strconv.ParseInt(Args[2], 10, 64)
+		// A kill date of 0 disables it, but must be explicitly requested so that it isn't done by mistake
+		if strings.ToLower(Args[1]) == "disable" {
+			job, err := addJob(agentID, "killdate", []string{Args[0], "0"})
+			if err != nil {
+				return messages.ErrorMessage(err.Error())
+			}
+			return messages.UserMessage{
+				Level:   messages.Note,
+				Message: fmt.Sprintf("Created job %s for agent %s to disable its kill date at %s", job, agentID, time.Now().UTC().Format(time.RFC3339)),
+				Time:    time.Now().UTC(),
+				Error:   false,
+			}
+		}
+		epoch, errU := strconv.ParseInt(Args[1], 10, 64)
 		if errU != nil {
 			m := fmt.Sprintf("There was an error converting %s to an int64", Args[1])
 			m = m + "\r\nKill date takes in a UNIX epoch timestamp such as 811123200 for September 15, 1995"
 			return messages.ErrorMessage(m)
 		}
-		job, err := jobs.Add(agentID, "killdate", Args)
+		if epoch == 0 {
+			return messages.ErrorMessage("a kill date of 0 disables the kill date, use \"killdate disable\" to confirm")
+		}
+		killDate := time.Unix(epoch, 0).UTC()
+		if epoch <= time.Now().Unix() {
+			return messages.ErrorMessage(fmt.Sprintf("the kill date %s is not in the future and would cause the agent to exit on its next check in", killDate.Format(time.RFC3339)))
+		}
+		job, err := addJob(agentID, "killdate", Args[0:2])
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
-		return messages.JobMessage(agentID, job)
+		return messages.UserMessage{
+			Level:   messages.Note,
+			Message: fmt.Sprintf("Created job %s for agent %s to set its kill date to %s at %s", job, agentID, killDate.Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339)),
+			Time:    time.Now().UTC(),
+			Error:   false,
+		}
 	}
 	return messages.ErrorMessage(fmt.Sprintf("Not enough arguments provided for the Agent SetKillDate call: %s", Args))
 }
@@ -587,7 +1164,7 @@ func KillProcess(agentID uuid.UUID, Args []string) messages.UserMessage {
 			return messages.ErrorMessage(fmt.Sprintf("Invalid PID provided: %s\n%s", Args[1], err))
 		}
 		args := []string{Args[1]}
-		job, err := jobs.Add(agentID, "killprocess", args)
+		job, err := addJob(agentID, "killprocess", args)
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -599,7 +1176,7 @@ func KillProcess(agentID uuid.UUID, Args []string) messages.UserMessage {
 // ListAssemblies instructs the agent to list all of the .NET assemblies that are currently loaded into the agent's process
 // .NET assemblies are loaded with the LoadAssembly call
 func ListAssemblies(agentID uuid.UUID) messages.UserMessage {
-	job, err := jobs.Add(agentID, "list-assemblies", []string{})
+	job, err := addJob(agentID, "list-assemblies", []string{})
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -616,7 +1193,7 @@ func LoadAssembly(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if err != nil {
 		return messages.ErrorMessage(fmt.Sprintf("there was an error accessing the assembly:\n%s", err))
 	}
-	job, err := jobs.Add(agentID, Args[0], Args[1:])
+	job, err := addJob(agentID, Args[0], Args[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -629,7 +1206,7 @@ func LoadCLR(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) < 1 {
 		return messages.ErrorMessage("not enough arguments, a .NET version must be provided")
 	}
-	job, err := jobs.Add(agentID, Args[0], Args[1:])
+	job, err := addJob(agentID, Args[0], Args[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -642,7 +1219,7 @@ func LS(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
 		args = []string{Args[1]}
 	}
-	job, err := jobs.Add(agentID, "ls", args)
+	job, err := addJob(agentID, "ls", args)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -657,7 +1234,7 @@ func MaxRetry(agentID uuid.UUID, Args []string) messages.UserMessage {
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
-		job, err := jobs.Add(agentID, "maxretry", Args)
+		job, err := addJob(agentID, "maxretry", Args)
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -671,7 +1248,7 @@ func MEMFD(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) < 1 {
 		return messages.ErrorMessage("not enough arguments. An executable was not provided")
 	}
-	job, err := jobs.Add(agentID, "memfd", Args[1:])
+	job, err := addJob(agentID, "memfd", Args[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -679,24 +1256,12 @@ func MEMFD(agentID uuid.UUID, Args []string) messages.UserMessage {
 }
 
 // Netstat is used to print network connections on the target system
-// Supports a "-p tcp" or "-p udp"
+// Supports a "-p tcp" or "-p udp" protocol filter and a "-s <state>" connection state filter (e.g., -s established)
 func Netstat(agentID uuid.UUID, Args []string) messages.UserMessage {
-	// Ensure the provided args are valid
 	// Args[0] = "netstat"
-	// Args[1] = (optional) "-p"
-	// Args[2] = (optional) "tcp" or "udp"
-	if len(Args) > 3 {
-		return messages.ErrorMessage("Too many arguments provided to the netstat command")
-	} else if len(Args) == 2 {
-		return messages.ErrorMessage("Incorrect arguments provided to the netstat command")
-	} else if len(Args) == 3 {
-		if Args[1] != "-p" {
-			return messages.ErrorMessage("Incorrect arguments provided to the netstat command")
-		} else if !(Args[2] == "tcp" || Args[2] == "udp") {
-			return messages.ErrorMessage("Incorrect arguments provided to the netstat command")
-		}
-	}
-	job, err := jobs.Add(agentID, "netstat", Args)
+	// Args[1:] = (optional) "-p" "tcp" or "udp" and (optional) "-s" <state>
+	// The arguments are validated when the job is created
+	job, err := addJob(agentID, "netstat", Args)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -705,24 +1270,55 @@ func Netstat(agentID uuid.UUID, Args []string) messages.UserMessage {
 
 // Note sets a note on the Agent's Note field
 func Note(agentID uuid.UUID, Args []string) messages.UserMessage {
-	note := strings.Join(Args, " ")
-	err := agents.SetAgentNote(agentID, note)
+	return SetNote(agentID, strings.Join(Args, " "))
+}
+
+// SetNote sets the operator's freeform note for the agent, shown in the agent's information but not the agents table.
+// An empty note removes it
+func SetNote(agentID uuid.UUID, text string) messages.UserMessage {
+	err := agents.SetAgentNote(agentID, text)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
 	return messages.UserMessage{
 		Level:   messages.Info,
 		Time:    time.Now().UTC(),
-		Message: fmt.Sprintf("Agent %s's note set to: %s", agentID, note),
+		Message: fmt.Sprintf("Agent %s's note set to: %s", agentID, text),
+	}
+}
+
+// AddAgentTag adds a label to the agent
+func AddAgentTag(agentID uuid.UUID, tag string) messages.UserMessage {
+	err := agents.AddAgentTag(agentID, tag)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Info,
+		Time:    time.Now().UTC(),
+		Message: fmt.Sprintf("Added tag %s to agent %s", tag, agentID),
+	}
+}
+
+// RemoveAgentTag removes a label from the agent
+func RemoveAgentTag(agentID uuid.UUID, tag string) messages.UserMessage {
+	err := agents.RemoveAgentTag(agentID, tag)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Info,
+		Time:    time.Now().UTC(),
+		Message: fmt.Sprintf("Removed tag %s from agent %s", tag, agentID),
 	}
 }
 
 // NSLOOKUP instructs the agent to perform a DNS query on the input
 func NSLOOKUP(agentID uuid.UUID, Args []string) messages.UserMessage {
-	if len(Args) < 1 {
+	if len(Args) < 2 {
 		return messages.ErrorMessage("not enough arguments. A query was not provided")
 	}
-	job, err := jobs.Add(agentID, "nslookup", Args[1:])
+	job, err := addJob(agentID, "nslookup", Args[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -732,7 +1328,7 @@ func NSLOOKUP(agentID uuid.UUID, Args []string) messages.UserMessage {
 // Padding configures the maxium size for the random amount of padding added to each message
 func Padding(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
-		job, err := jobs.Add(agentID, "padding", Args)
+		job, err := addJob(agentID, "padding", Args)
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -743,7 +1339,41 @@ func Padding(agentID uuid.UUID, Args []string) messages.UserMessage {
 
 // Pipes enumerates and displays named pipes on Windows hosts only
 func Pipes(agentID uuid.UUID) messages.UserMessage {
-	job, err := jobs.Add(agentID, "pipes", nil)
+	job, err := addJob(agentID, "pipes", nil)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// PortForward sets up, tears down, or lists the agent's port forwards
+// Args[0] = portfwd
+// Args[1] = forward, reverse, stop, or list
+// Args[2] = the listen address:port
+// Args[3] = the remote address:port
+func PortForward(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("not enough arguments provided for the portfwd command.\nportfwd <forward | reverse | stop | list> [listen address:port] [remote address:port]")
+	}
+	job, err := addJob(agentID, "portfwd", Args[1:])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// Registry queries, adds, or deletes Windows registry keys and values
+// Args[0] = registry
+// Args[1] = query, add, or delete
+// Args[2] = the key path starting with the hive such as HKLM\SOFTWARE\Microsoft
+// Args[3] = the value name, optional for query and delete
+// Args[4] = the value's data type for add
+// Args[5] = the value's data for add
+func Registry(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 3 {
+		return messages.ErrorMessage("not enough arguments provided for the registry command.\nregistry <query | add | delete> <key path> [value name] [data type] [data]")
+	}
+	job, err := addJob(agentID, "registry", Args[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -751,8 +1381,14 @@ func Pipes(agentID uuid.UUID) messages.UserMessage {
 }
 
 // PS displays running processes
-func PS(agentID uuid.UUID) messages.UserMessage {
-	job, err := jobs.Add(agentID, "ps", nil)
+// Args[0] = ps
+// Args[1] = an optional process name to filter by
+func PS(agentID uuid.UUID, Args []string) messages.UserMessage {
+	var args []string
+	if len(Args) > 1 {
+		args = Args[1:2]
+	}
+	job, err := addJob(agentID, "ps", args)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -761,21 +1397,22 @@ func PS(agentID uuid.UUID) messages.UserMessage {
 
 // PWD is used to print the Agent's current working directory
 func PWD(agentID uuid.UUID, Args []string) messages.UserMessage {
-	job, err := jobs.Add(agentID, "pwd", Args)
+	job, err := addJob(agentID, "pwd", Args)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
 	return messages.JobMessage(agentID, job)
 }
 
-// Remove deletes the agent from the server
+// Remove deletes the agent, and all of its jobs, from the server
 func Remove(agentID uuid.UUID) messages.UserMessage {
 	err := agents.RemoveAgent(agentID)
 	if err == nil {
+		purged := jobs.PurgeAgent(agentID)
 		return messages.UserMessage{
 			Level:   messages.Info,
 			Time:    time.Now().UTC(),
-			Message: fmt.Sprintf("Agent %s was removed from the server at %s", agentID, time.Now().UTC().Format(time.RFC3339)),
+			Message: fmt.Sprintf("Agent %s and its %d jobs were removed from the server at %s", agentID, purged, time.Now().UTC().Format(time.RFC3339)),
 		}
 	}
 	return messages.ErrorMessage(err.Error())
@@ -786,7 +1423,624 @@ func SecureDelete(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) < 2 {
 		return messages.ErrorMessage("Not enough arguments. A file path was not provided.")
 	}
-	job, err := jobs.Add(agentID, "sdelete", Args)
+	job, err := addJob(agentID, "sdelete", Args)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// SetJobTTL sets the default amount of time a new job can go without completing before it expires
+// Args[0] = Go duration string (e.g., 24h); 0 disables expiration
+func SetJobTTL(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a duration (e.g., 24h) must be provided")
+	}
+	ttl, err := time.ParseDuration(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error parsing %s to a duration:\r\n%s", Args[0], err))
+	}
+	err = jobs.SetTTL(ttl)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("New jobs will expire after %s", ttl),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetWriteRetry sets how many times a failed write of a downloaded file is retried and the delay before the first retry
+// Args[0] = the number of retries
+// Args[1] = the delay (e.g., 500ms) before the first retry, which doubles for each retry after it
+func SetWriteRetry(Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("not enough arguments provided, a number of retries and a delay (e.g., 500ms) must be provided")
+	}
+	retries, err := strconv.Atoi(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	delay, err := time.ParseDuration(Args[1])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error parsing %s to a duration:\r\n%s", Args[1], err))
+	}
+	err = jobs.SetWriteRetry(retries, delay)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Failed writes of downloaded files will be retried %d times starting after %s", retries, delay),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetJobJSONOutput writes all job results as newline-delimited JSON to the provided file, or stops when "off" is provided
+func SetJobJSONOutput(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a file path or \"off\" must be provided")
+	}
+	var f *os.File
+	if strings.ToLower(Args[0]) != "off" {
+		var err error
+		f, err = os.OpenFile(filepath.Clean(Args[0]), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return messages.ErrorMessage(fmt.Sprintf("there was an error opening %s for JSON output:\r\n%s", Args[0], err))
+		}
+		jobs.SetJSONOutput(f)
+	} else {
+		jobs.SetJSONOutput(nil)
+	}
+
+	// Close the previous file after the new writer is in place
+	if jsonFile != nil {
+		err := jsonFile.Close()
+		if err != nil {
+			return messages.ErrorMessage(fmt.Sprintf("there was an error closing the previous JSON output file %s:\r\n%s", jsonFile.Name(), err))
+		}
+	}
+	jsonFile = f
+
+	m := "JSON output disabled"
+	if f != nil {
+		m = fmt.Sprintf("Job results will be written as JSON to %s", f.Name())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetUploadChunkSize sets the number of bytes in each chunk of a file uploaded to an agent
+func SetUploadChunkSize(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a number of bytes must be provided")
+	}
+	size, err := strconv.ParseInt(Args[0], 10, 64)
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	err = jobs.SetUploadChunkSize(size)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Files larger than %d bytes will be uploaded in chunks", size),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetMaxUploadSize sets the largest file, in bytes, that can be uploaded to an agent
+func SetMaxUploadSize(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a number of bytes must be provided")
+	}
+	size, err := strconv.ParseInt(Args[0], 10, 64)
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	err = jobs.SetMaxUploadSize(size)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	m := fmt.Sprintf("Files larger than %d bytes can not be uploaded to agents", size)
+	if size == 0 {
+		m = "Files of any size can be uploaded to agents"
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetPaddingCharset sets the characters that the padding of messages returned to agents is made of
+func SetPaddingCharset(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a padding charset must be provided")
+	}
+	err := jobs.SetPaddingCharset(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Message padding will be made of the characters %s", Args[0]),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetPaddingDistribution sets whether the padding of messages returned to agents is a fixed or uniformly random length
+func SetPaddingDistribution(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a padding distribution of fixed or uniform must be provided")
+	}
+	err := jobs.SetPaddingDistribution(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Message padding will use the %s distribution", strings.ToLower(Args[0])),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetResponseBudget sets the maximum number of bytes of jobs returned to an agent in one response
+// Args[0] = the number of bytes, 0 disables the limit
+// Args[1] = optionally, the transport protocol (e.g., http3 or h2) the budget is for. Without it the budget is for every
+// transport that doesn't have its own budget
+func SetResponseBudget(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a number of bytes must be provided")
+	}
+	size, err := strconv.Atoi(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	var proto string
+	if len(Args) > 1 {
+		proto = Args[1]
+	}
+	err = jobs.SetResponseBudget(proto, size)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	transport := "agents"
+	if proto != "" {
+		transport = fmt.Sprintf("%s agents", strings.ToLower(proto))
+	}
+	m := fmt.Sprintf("Responses to %s will contain up to %d bytes of jobs", transport, size)
+	if size == 0 {
+		m = fmt.Sprintf("Responses to %s will contain every queued job", transport)
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetJobQueueSize sets the maximum number of jobs that can wait to be sent to each agent
+func SetJobQueueSize(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a number of jobs must be provided")
+	}
+	size, err := strconv.Atoi(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	err = jobs.SetQueueSize(size)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Up to %d jobs can be queued for each agent", size),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetCommandDenylist sets the commands that run, exec, and shell jobs are not allowed to execute
+// Args = a comma or space separated list of command names, or "none" to remove the denylist
+func SetCommandDenylist(Args []string) messages.UserMessage {
+	commands := commandNames(Args)
+	err := jobs.SetCommandDenylist(commands)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	m := "The command denylist was removed"
+	if len(commands) > 0 {
+		m = fmt.Sprintf("Jobs will not be created for the commands: %s", strings.Join(commands, ", "))
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetCommandAllowlist sets the only commands that run, exec, and shell jobs can execute
+// Args = a comma or space separated list of command names, or "none" to remove the allowlist
+func SetCommandAllowlist(Args []string) messages.UserMessage {
+	commands := commandNames(Args)
+	err := jobs.SetCommandAllowlist(commands)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	m := "The command allowlist was removed"
+	if len(commands) > 0 {
+		m = fmt.Sprintf("Jobs will only be created for the commands: %s", strings.Join(commands, ", "))
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// commandNames splits the comma or space separated command names of a denylist or allowlist. "none" returns no names
+func commandNames(Args []string) []string {
+	names := strings.FieldsFunc(strings.Join(Args, " "), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(names) == 1 && strings.ToLower(names[0]) == "none" {
+		return nil
+	}
+	return names
+}
+
+// AddJobRedaction adds a regular expression whose matches in job results are replaced with [REDACTED]
+// Args = the regular expression, arguments are joined with a space
+func AddJobRedaction(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a regular expression must be provided")
+	}
+	pattern := strings.Join(Args, " ")
+	err := jobs.AddRedaction(pattern)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Matches of %q will be redacted from job results", pattern),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// RemoveJobRedaction removes a regular expression that was added with AddJobRedaction
+// Args = the regular expression, arguments are joined with a space
+func RemoveJobRedaction(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a regular expression must be provided")
+	}
+	pattern := strings.Join(Args, " ")
+	err := jobs.RemoveRedaction(pattern)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Matches of %q will no longer be redacted from job results", pattern),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GetJobRedactions returns a message listing the regular expressions that are redacted from job results
+func GetJobRedactions() messages.UserMessage {
+	patterns := jobs.Redactions()
+	if len(patterns) == 0 {
+		return messages.UserMessage{
+			Level:   messages.Note,
+			Message: "There are no job result redaction patterns",
+			Time:    time.Now().UTC(),
+			Error:   false,
+		}
+	}
+	return messages.UserMessage{
+		Level:   messages.Info,
+		Message: fmt.Sprintf("Job result redaction patterns:\r\n%s", strings.Join(patterns, "\r\n")),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetJobRedaction enables or disables redacting job results
+// Args[0] = true or false
+func SetJobRedaction(Args []string) messages.UserMessage {
+	enabled, err := toggle(Args)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	jobs.SetRedaction(enabled)
+	m := "Job results will be redacted"
+	if !enabled {
+		m = "Job results will not be redacted"
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetKeepUnredacted enables or disables storing a job's original results alongside its redacted results
+// Args[0] = true or false
+func SetKeepUnredacted(Args []string) messages.UserMessage {
+	enabled, err := toggle(Args)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	jobs.SetKeepUnredacted(enabled)
+	m := "The original results of redacted jobs will be kept"
+	if !enabled {
+		m = "The original results of redacted jobs will not be kept"
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// toggle parses the true or false argument of a setting that is enabled or disabled
+func toggle(Args []string) (bool, error) {
+	if len(Args) < 1 {
+		return false, fmt.Errorf("not enough arguments provided, true or false must be provided")
+	}
+	switch strings.ToLower(Args[0]) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid value %s, true or false must be provided", Args[0])
+	}
+}
+
+// GetUnredactedResults returns a job's original results that were kept while SetKeepUnredacted was enabled
+func GetUnredactedResults(jobID string) messages.UserMessage {
+	result, err := jobs.GetUnredactedResults(jobID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	m := fmt.Sprintf("Unredacted results for job %s", jobID)
+	if result.Stdout != "" {
+		m += fmt.Sprintf("\r\n%s", result.Stdout)
+	}
+	if result.Stderr != "" {
+		m += fmt.Sprintf("\r\nCommand Results (stderr):\r\n%s", result.Stderr)
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetJobResultLimit sets the maximum number of bytes of stdout, and of stderr, stored for each job
+func SetJobResultLimit(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a number of bytes must be provided")
+	}
+	limit, err := strconv.Atoi(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error converting %s to an integer:\r\n%s", Args[0], err))
+	}
+	err = jobs.SetResultLimit(limit)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Job results will be truncated to %d bytes", limit),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetJobRetention sets how long completed, canceled, and expired jobs are kept before they are pruned
+func SetJobRetention(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a duration (e.g., 72h) must be provided")
+	}
+	retention, err := time.ParseDuration(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(fmt.Sprintf("there was an error parsing %s to a duration:\r\n%s", Args[0], err))
+	}
+	err = jobs.SetRetention(retention)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Finished jobs will be pruned after %s", retention),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetDownloadNaming sets the scheme used to name files downloaded from an agent: basename, jobid, timestamp, or path
+func SetDownloadNaming(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a naming scheme must be provided")
+	}
+	err := jobs.SetNaming(Args[0])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Downloaded files will be named using the %s scheme", strings.ToLower(Args[0])),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// SetDownloadDir sets the directory where each agent's downloaded files are written. "default" restores data/agents
+func SetDownloadDir(Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage("not enough arguments provided, a directory or \"default\" must be provided")
+	}
+	dir := strings.Join(Args, " ")
+	if strings.ToLower(dir) == "default" {
+		dir = ""
+	}
+	err := jobs.SetDownloadDir(dir)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	m := "Downloaded files will be written to the default data/agents directory"
+	if dir != "" {
+		m = fmt.Sprintf("Downloaded files will be written to %s", dir)
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// ReadMemory tasks the agent to read a region of a process's memory and return the bytes as Base64 in the job results
+// Args[0] = "readmemory"
+// Args[1] = the target process ID
+// Args[2] = the base address in decimal or hex with a 0x prefix
+// Args[3] = the number of bytes to read
+func ReadMemory(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 4 {
+		return messages.ErrorMessage("not enough arguments provided, a process ID, base address, and length must be provided")
+	}
+	job, err := addJob(agentID, "ReadMemory", Args[1:4])
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// Screenshot captures an image of the agent's screen that is downloaded to the agent's screenshots directory
+// Args[0] = screenshot
+// Args[1] = the optional index of the display to capture
+func Screenshot(agentID uuid.UUID, Args []string) messages.UserMessage {
+	var args []string
+	if len(Args) > 1 {
+		display, err := strconv.Atoi(Args[1])
+		if err != nil || display < 0 {
+			return messages.ErrorMessage(fmt.Sprintf("invalid display index %s, expected a number greater than or equal to 0", Args[1]))
+		}
+		args = Args[1:2]
+	}
+	job, err := addJob(agentID, "screenshot", args)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// StartShell opens an interactive shell session on the agent where each line of input is sent as its own job
+func StartShell(agentID uuid.UUID) messages.UserMessage {
+	sessionID, err := jobs.StartShell(agentID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Started shell session %s for agent %s", sessionID, agentID),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// ShellInput sends a line of input, made from all of the arguments, to the agent for the interactive shell session
+func ShellInput(sessionID string, Args []string) messages.UserMessage {
+	if len(Args) < 1 {
+		return messages.ErrorMessage(fmt.Sprintf("a command must be provided for shell session %s", sessionID))
+	}
+	jobID, err := jobs.ShellInput(sessionID, strings.Join(Args, " "))
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Note,
+		Message: fmt.Sprintf("Created job %s for shell session %s at %s", jobID, sessionID, time.Now().UTC().Format(time.RFC3339)),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// GetShellOutput returns the results received for the interactive shell session since its output was last read
+func GetShellOutput(sessionID string) messages.UserMessage {
+	output, err := jobs.ShellOutput(sessionID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	if len(output) == 0 {
+		return messages.UserMessage{
+			Level:   messages.Note,
+			Message: fmt.Sprintf("Shell session %s does not have any new output", sessionID),
+			Time:    time.Now().UTC(),
+			Error:   false,
+		}
+	}
+	m := fmt.Sprintf("Output for shell session %s", sessionID)
+	for _, result := range output {
+		if result.Stdout != "" {
+			m += fmt.Sprintf("\r\n%s", result.Stdout)
+		}
+		if result.Stderr != "" {
+			m += fmt.Sprintf("\r\nCommand Results (stderr):\r\n%s", result.Stderr)
+		}
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: m,
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// StopShell closes the interactive shell session
+func StopShell(sessionID string) messages.UserMessage {
+	err := jobs.StopShell(sessionID)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.UserMessage{
+		Level:   messages.Success,
+		Message: fmt.Sprintf("Closed shell session %s", sessionID),
+		Time:    time.Now().UTC(),
+		Error:   false,
+	}
+}
+
+// StealToken impersonates the token of another process
+// Args[0] = steal_token
+// Args[1] = the ID of the process whose token to impersonate
+func StealToken(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 2 {
+		return messages.ErrorMessage("not enough arguments provided for the steal_token command.\nsteal_token <pid>")
+	}
+	job, err := addJob(agentID, "steal_token", Args[1:2])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -845,7 +2099,7 @@ func SharpGen(agentID uuid.UUID, Args []string) messages.UserMessage {
 	}
 
 	// Add job to the Agent's queue
-	job, err := jobs.Add(agentID, j[0], j[1:])
+	job, err := addJob(agentID, j[0], j[1:])
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -855,7 +2109,7 @@ func SharpGen(agentID uuid.UUID, Args []string) messages.UserMessage {
 // Skew configures the amount of skew an Agent uses to randomize checkin times
 func Skew(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
-		job, err := jobs.Add(agentID, "skew", Args)
+		job, err := addJob(agentID, "skew", Args)
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -864,6 +2118,15 @@ func Skew(agentID uuid.UUID, Args []string) messages.UserMessage {
 	return messages.ErrorMessage(fmt.Sprintf("Not enough arguments provided for the Agent SetSkew call: %s", Args))
 }
 
+// SetJitter configures the percentage, 0 to 100, of the Agent's sleep time that it uses to randomize checkin times
+func SetJitter(agentID uuid.UUID, percent string) messages.UserMessage {
+	job, err := addJob(agentID, "jitter", []string{percent})
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
 // Sleep configures the Agent's sleep time between checkins
 func Sleep(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) > 1 {
@@ -872,7 +2135,7 @@ func Sleep(agentID uuid.UUID, Args []string) messages.UserMessage {
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
-		job, err := jobs.Add(agentID, "sleep", Args)
+		job, err := addJob(agentID, "sleep", Args)
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -886,7 +2149,34 @@ func Touch(agentID uuid.UUID, Args []string) messages.UserMessage {
 	if len(Args) < 3 {
 		return messages.ErrorMessage("Not enough arguments.")
 	}
-	job, err := jobs.Add(agentID, "touch", Args)
+	job, err := addJob(agentID, "touch", Args)
+	if err != nil {
+		return messages.ErrorMessage(err.Error())
+	}
+	return messages.JobMessage(agentID, job)
+}
+
+// Timestomp sets a file's access and modify times to an explicit time or to the times of another file on the agent.
+// Unlike Touch, the destination file comes first. An explicit time is sent as a timestomp job and a source file as a
+// touch job, so agents that only know the touch command can still clone a file's times
+// Args[0] = "stomp"
+// Args[1] = the destination file on the agent
+// Args[2] = an RFC3339 time (e.g., 2021-01-02T15:04:05Z) or the source file on the agent to copy times from
+func Timestomp(agentID uuid.UUID, Args []string) messages.UserMessage {
+	if len(Args) < 3 {
+		return messages.ErrorMessage("not enough arguments provided, a destination file and an RFC3339 time or source file must be provided")
+	}
+	if Args[1] == "" || Args[2] == "" {
+		return messages.ErrorMessage("the destination file and the RFC3339 time or source file can not be empty")
+	}
+	var job string
+	var err error
+	if _, errTime := time.Parse(time.RFC3339, Args[2]); errTime == nil {
+		job, err = addJob(agentID, "timestomp", []string{Args[1], Args[2]})
+	} else {
+		// The source file's times are cloned by the existing touch command
+		job, err = addJob(agentID, "touch", []string{"touch", Args[2], Args[1]})
+	}
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
@@ -898,13 +2188,18 @@ func Upload(agentID uuid.UUID, Args []string) messages.UserMessage {
 	// Make sure there are enough arguments
 	// Validate the source file exists
 	// Create job
-	if len(Args) >= 3 {
-		_, errF := os.Stat(Args[1])
+	// A leading force flag allows a destination that contains path traversal
+	source := 1
+	if len(Args) > 1 && Args[1] == jobs.ForceFlag {
+		source = 2
+	}
+	if len(Args) >= source+2 {
+		_, errF := os.Stat(Args[source])
 		if errF != nil {
 			m := fmt.Sprintf("there was an error accessing the source upload file:\r\n%s", errF.Error())
 			return messages.ErrorMessage(m)
 		}
-		job, err := jobs.Add(agentID, "upload", Args[1:3])
+		job, err := addJob(agentID, "upload", Args[1:])
 		if err != nil {
 			return messages.ErrorMessage(err.Error())
 		}
@@ -916,13 +2211,29 @@ func Upload(agentID uuid.UUID, Args []string) messages.UserMessage {
 
 // Uptime retrieves the target host's uptime. Windows only
 func Uptime(agentID uuid.UUID) messages.UserMessage {
-	job, err := jobs.Add(agentID, "uptime", nil)
+	job, err := addJob(agentID, "uptime", nil)
 	if err != nil {
 		return messages.ErrorMessage(err.Error())
 	}
 	return messages.JobMessage(agentID, job)
 }
 
+// addJob creates the job for the agent and returns its ID. When the agent is the broadcast identifier, a job is created
+// for every agent and each job ID is returned in a comma separated list
+func addJob(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
+	if agentID.String() != "ffffffff-ffff-ffff-ffff-ffffffffffff" {
+		return jobs.Add(agentID, jobType, jobArgs)
+	}
+	jobIDs, err := jobs.AddAll(jobType, jobArgs)
+	if err != nil {
+		if len(jobIDs) > 0 {
+			return "", fmt.Errorf("%s\r\njobs %s were created before the error", err, strings.Join(jobIDs, ", "))
+		}
+		return "", err
+	}
+	return strings.Join(jobIDs, ", "), nil
+}
+
 // lastCheckin returns a nicely formatted string for time since the last checkin (HH:MM:SS)
 func lastCheckin(t time.Time) string {
 	lastTime := time.Since(t)
@@ -0,0 +1,628 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+	"github.com/Ne0nd0g/merlin/pkg/server/jobs"
+)
+
+// TestMain points core.CurrentDir at a temporary directory so that tests don't write to the package's directory
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "merlin")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	core.CurrentDir = dir
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TestKillDate verifies that kill dates that are not in the future are rejected before a job is created
+func TestKillDate(t *testing.T) {
+	agentID := uuid.NewV4()
+	tests := []struct {
+		epoch string
+		error bool
+	}{
+		{"811123200", true},
+		{"0", true},
+		{"notANumber", true},
+		{strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10), false},
+		{"disable", false},
+	}
+	for _, test := range tests {
+		m := KillDate(agentID, []string{"killdate", test.epoch})
+		if m.Error != test.error {
+			t.Errorf("killdate %s: expected error to be %t, received %t: %s", test.epoch, test.error, m.Error, m.Message)
+		}
+	}
+	if m := KillDate(agentID, []string{"killdate"}); !m.Error {
+		t.Error("expected an error when a kill date is not provided")
+	}
+}
+
+// TestAgentTags verifies tags are added, removed, and displayed in an aligned agents table column
+func TestAgentTags(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID, WaitTime: "10s"}
+	defer delete(agents.Agents, agentID)
+
+	for _, tag := range []string{"DMZ", "high-value", "DMZ"} {
+		if m := AddAgentTag(agentID, tag); m.Error {
+			t.Fatal(m.Message)
+		}
+	}
+	if m := RemoveAgentTag(agentID, "DMZ"); m.Error {
+		t.Fatal(m.Message)
+	}
+	if m := RemoveAgentTag(agentID, "DMZ"); !m.Error {
+		t.Error("expected an error removing a tag the agent doesn't have")
+	}
+	if m := AddAgentTag(uuid.NewV4(), "DMZ"); !m.Error {
+		t.Error("expected an error adding a tag to an unknown agent")
+	}
+
+	header, rows := GetAgentsRows()
+	for _, row := range rows {
+		if len(row) != len(header) {
+			t.Fatalf("expected %d columns, received %d: %v", len(header), len(row), row)
+		}
+		if row[0] == agentID.String() && row[len(row)-1] != "high-value" {
+			t.Errorf("expected the Tags column to contain high-value, received %q", row[len(row)-1])
+		}
+	}
+}
+
+// TestGetAgentStatusThresholds verifies agents are Active, Delayed, or Dead using custom thresholds
+func TestGetAgentStatusThresholds(t *testing.T) {
+	defer SetStatusThresholds([]string{"1", "1"})
+	if m := SetStatusThresholds([]string{"2", "3"}); m.Error {
+		t.Fatal(m.Message)
+	}
+
+	// With a 10 second wait time and 2 retries, Delayed starts after 20 seconds and Dead after 90 seconds
+	tests := []struct {
+		checkIn time.Duration
+		status  string
+	}{
+		{15 * time.Second, "Active"},
+		{60 * time.Second, "Delayed"},
+		{100 * time.Second, "Dead"},
+	}
+	for _, test := range tests {
+		agentID := uuid.NewV4()
+		agents.Agents[agentID] = &agents.Agent{
+			ID:            agentID,
+			WaitTime:      "10s",
+			MaxRetry:      2,
+			StatusCheckIn: time.Now().Add(-test.checkIn),
+		}
+		status, m := GetAgentStatus(agentID)
+		delete(agents.Agents, agentID)
+		if m.Error {
+			t.Fatal(m.Message)
+		}
+		if status != test.status {
+			t.Errorf("last check in %s ago: expected status %s, received %s", test.checkIn, test.status, status)
+		}
+	}
+
+	if m := SetStatusThresholds([]string{"0", "1"}); !m.Error {
+		t.Error("expected an error for a multiplier of 0")
+	}
+	if m := SetStatusThresholds([]string{"1", "-1"}); !m.Error {
+		t.Error("expected an error for a negative multiplier")
+	}
+	if m := SetStatusThresholds([]string{"3", "2"}); !m.Error {
+		t.Error("expected an error for a Dead multiplier less than the Delayed multiplier")
+	}
+}
+
+// TestSetStatusThresholdsConcurrent verifies the thresholds can be set while agent statuses are read
+func TestSetStatusThresholdsConcurrent(t *testing.T) {
+	defer SetStatusThresholds([]string{"1", "1"})
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID, WaitTime: "10s", MaxRetry: 2, StatusCheckIn: time.Now()}
+	defer delete(agents.Agents, agentID)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetStatusThresholds([]string{"2", "3"})
+		}()
+		go func() {
+			defer wg.Done()
+			GetAgentStatus(agentID)
+		}()
+	}
+	wg.Wait()
+}
+
+// TestNSLOOKUP verifies that a DNS query creates a job and that a missing query is rejected
+func TestNSLOOKUP(t *testing.T) {
+	agentID := uuid.NewV4()
+	if m := NSLOOKUP(agentID, []string{"nslookup", "example.com", "8.8.8.8"}); m.Error {
+		t.Errorf("expected a job to be created, received: %s", m.Message)
+	}
+	if m := NSLOOKUP(agentID, []string{"nslookup"}); !m.Error {
+		t.Error("expected an error when a query is not provided")
+	}
+}
+
+// TestTimestomp verifies that both an explicit time and a source file create a job, that missing paths are rejected,
+// and that touch keeps sending the source file before the destination file
+func TestTimestomp(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID}
+	defer delete(agents.Agents, agentID)
+	tests := []struct {
+		args    []string
+		command string
+		wire    []string
+		error   bool
+	}{
+		{[]string{"stomp", "/tmp/merlin.txt", "2021-01-02T15:04:05Z"}, "timestomp", []string{"/tmp/merlin.txt", "2021-01-02T15:04:05Z"}, false},
+		{[]string{"stomp", "/tmp/merlin.txt", "/etc/passwd"}, "touch", []string{"touch", "/etc/passwd", "/tmp/merlin.txt"}, false},
+		{[]string{"stomp", "/tmp/merlin.txt"}, "", nil, true},
+		{[]string{"stomp", "", "2021-01-02T15:04:05Z"}, "", nil, true},
+	}
+	for _, test := range tests {
+		m := Timestomp(agentID, test.args)
+		if m.Error != test.error {
+			t.Errorf("%v: expected error to be %t, received %t: %s", test.args, test.error, m.Error, m.Message)
+		}
+	}
+	if m := Touch(agentID, []string{"timestomp", "/etc/passwd", "/tmp/merlin.txt"}); m.Error {
+		t.Fatal(m.Message)
+	}
+
+	history, err := jobs.History(agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Jobs created within the same instant aren't ordered, so each job is matched by its command and arguments
+	sent := make(map[string]bool)
+	for _, j := range history {
+		if p, ok := j.Payload.(merlinJob.Command); ok {
+			sent[p.Command+" "+strings.Join(p.Args, "|")] = true
+		}
+	}
+	expected := []string{
+		tests[0].command + " " + strings.Join(tests[0].wire, "|"),
+		tests[1].command + " " + strings.Join(tests[1].wire, "|"),
+		"touch timestomp|/etc/passwd|/tmp/merlin.txt",
+	}
+	if len(history) != len(expected) {
+		t.Errorf("expected %d jobs, received %d", len(expected), len(history))
+	}
+	for _, e := range expected {
+		if !sent[e] {
+			t.Errorf("expected a job with the command and arguments %q, received %v", e, sent)
+		}
+	}
+}
+
+// TestAddJobBroadcast verifies that a job created for the broadcast identifier reports the ID of every agent's job
+func TestAddJobBroadcast(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		agentID := uuid.NewV4()
+		agents.Agents[agentID] = &agents.Agent{ID: agentID}
+	}
+	broadcastID, err := uuid.FromString("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobIDs, err := addJob(broadcastID, "run", []string{"whoami"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Split(jobIDs, ", ")); n != len(agents.Agents) {
+		t.Errorf("expected %d job IDs, received %d: %s", len(agents.Agents), n, jobIDs)
+	}
+
+	m := CMD(broadcastID, []string{"run", "whoami"})
+	if m.Error {
+		t.Fatal(m.Message)
+	}
+	if n := strings.Count(m.Message, ", ") + 1; n != len(agents.Agents) {
+		t.Errorf("expected the message to contain %d job IDs: %s", len(agents.Agents), m.Message)
+	}
+}
+
+// TestGetAgentInfoLastResult verifies the last time the agent returned results is displayed, or Never if it hasn't
+func TestGetAgentInfoLastResult(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID, WaitTime: "10s", StatusCheckIn: time.Now()}
+	defer delete(agents.Agents, agentID)
+
+	lastResult := func() string {
+		rows, m := GetAgentInfo(agentID)
+		if m.Error {
+			t.Fatal(m.Message)
+		}
+		for _, row := range rows {
+			if row[0] == "Last Result" {
+				return row[1]
+			}
+		}
+		t.Fatal("the agent information did not contain a Last Result row")
+		return ""
+	}
+	if r := lastResult(); r != "Never" {
+		t.Errorf("expected Never before any results were received, received %s", r)
+	}
+	received := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	agents.Agents[agentID].LastResult = received
+	if r := lastResult(); r != received.Format(time.RFC3339) {
+		t.Errorf("expected %s, received %s", received.Format(time.RFC3339), r)
+	}
+}
+
+// TestCheckInWindow verifies the expected check in window is the wait time plus or minus the skew
+func TestCheckInWindow(t *testing.T) {
+	tests := []struct {
+		waitTime string
+		skew     int64
+		expected string
+	}{
+		{"30s", 5000, "25s to 35s (30s ± 5s)"},
+		{"10s", 0, "10s to 10s (10s ± 0s)"},
+		{"2s", 3500, "0s to 5.5s (2s ± 3.5s)"},
+		{"", 1000, "unknown"},
+		{"soon", 1000, "unknown"},
+	}
+	for _, test := range tests {
+		if window := checkInWindow(test.waitTime, test.skew); window != test.expected {
+			t.Errorf("expected %q for a wait time of %q and skew of %d, received %q", test.expected, test.waitTime, test.skew, window)
+		}
+	}
+
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID, WaitTime: "30s", Skew: 5000, StatusCheckIn: time.Now()}
+	defer delete(agents.Agents, agentID)
+	rows, m := GetAgentInfo(agentID)
+	if m.Error {
+		t.Fatal(m.Message)
+	}
+	for _, row := range rows {
+		if row[0] == "Expected Check-In Window" {
+			if row[1] != "25s to 35s (30s ± 5s)" {
+				t.Errorf("expected the agent's check in window, received %s", row[1])
+			}
+			return
+		}
+	}
+	t.Error("the agent information did not contain an Expected Check-In Window row")
+}
+
+// TestFindAgents verifies agents are found by case-insensitive substrings of their hostname or username
+func TestFindAgents(t *testing.T) {
+	seed := map[string][2]string{
+		"web":   {"WEB01.corp.local", "CORP\\svc_web"},
+		"db":    {"db01.corp.local", "root"},
+		"kiosk": {"Kiosk-Lobby", "Guest"},
+	}
+	ids := make(map[string]uuid.UUID)
+	for name, host := range seed {
+		agentID := uuid.NewV4()
+		agents.Agents[agentID] = &agents.Agent{ID: agentID, HostName: host[0], UserName: host[1], WaitTime: "10s"}
+		ids[name] = agentID
+		defer delete(agents.Agents, agentID)
+	}
+
+	tests := []struct {
+		find     func(string) []uuid.UUID
+		substr   string
+		expected []string
+	}{
+		{FindByHostname, "corp.LOCAL", []string{"web", "db"}},
+		{FindByHostname, "kiosk", []string{"kiosk"}},
+		{FindByHostname, "mail", nil},
+		{FindByUser, "SVC_", []string{"web"}},
+		{FindByUser, "guest", []string{"kiosk"}},
+		{FindByUser, "admin", nil},
+	}
+	for _, test := range tests {
+		found := make(map[uuid.UUID]bool)
+		for _, id := range test.find(test.substr) {
+			found[id] = true
+		}
+		for name, id := range ids {
+			var want bool
+			for _, e := range test.expected {
+				want = want || e == name
+			}
+			if found[id] != want {
+				t.Errorf("%s: expected agent %s to match to be %t, received %t", test.substr, name, want, found[id])
+			}
+		}
+	}
+
+	found := FindAgents(func(agent agents.Agent) bool { return strings.HasSuffix(agent.HostName, ".corp.local") })
+	if len(found) != 2 || found[0].String() > found[1].String() {
+		t.Errorf("expected 2 sorted agents, received %v", found)
+	}
+	_, rows := SearchAgentsRows("root")
+	if len(rows) != 1 || rows[0][0] != ids["db"].String() {
+		t.Errorf("expected only the db agent's row, received %v", rows)
+	}
+}
+
+// TestExecuteBOF verifies a BOF is read from the server before a job is created
+func TestExecuteBOF(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID, WaitTime: "10s"}
+	defer delete(agents.Agents, agentID)
+
+	if m := ExecuteBOF(agentID, []string{"execute-bof"}); !m.Error {
+		t.Error("expected an error when the BOF path is not provided")
+	}
+	missing := filepath.Join(t.TempDir(), "missing.o")
+	if m := ExecuteBOF(agentID, []string{"execute-bof", missing}); !m.Error || !strings.Contains(m.Message, missing) {
+		t.Errorf("expected an error naming the missing BOF, received %q", m.Message)
+	}
+
+	// An x64 COFF file header
+	bof := filepath.Join(t.TempDir(), "whoami.x64.o")
+	if err := ioutil.WriteFile(bof, append([]byte{0x64, 0x86}, make([]byte, 62)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if m := ExecuteBOF(agentID, []string{"execute-bof", bof, "z:merlin", "i:1"}); m.Error {
+		t.Errorf("expected the BOF job to be created, received %q", m.Message)
+	}
+	if m := ExecuteBOF(agentID, []string{"execute-bof", bof, "merlin"}); !m.Error {
+		t.Error("expected an error for an argument without a type")
+	}
+}
+
+// TestGetCheckInHistory verifies an agent's check ins are returned oldest first and bounded by the history size
+func TestGetCheckInHistory(t *testing.T) {
+	defer SetCheckInHistorySize([]string{"50"})
+	if m := SetCheckInHistorySize([]string{"0"}); !m.Error {
+		t.Error("expected an error for a history size of 0")
+	}
+	if m := SetCheckInHistorySize([]string{"3"}); m.Error {
+		t.Fatal(m.Message)
+	}
+
+	agentID := uuid.NewV4()
+	agent := &agents.Agent{ID: agentID, WaitTime: "10s"}
+	agents.Agents[agentID] = agent
+	defer delete(agents.Agents, agentID)
+
+	if history, m := GetCheckInHistory(agentID); m.Error || len(history) != 0 {
+		t.Errorf("expected an empty history before the agent checked in, received %v: %s", history, m.Message)
+	}
+	for i := 0; i < 5; i++ {
+		agent.CheckIn()
+		time.Sleep(time.Millisecond)
+	}
+	history, m := GetCheckInHistory(agentID)
+	if m.Error {
+		t.Fatal(m.Message)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected the history to be bounded to 3 check ins, received %d", len(history))
+	}
+	for i := 1; i < len(history); i++ {
+		if !history[i].After(history[i-1]) {
+			t.Errorf("expected the history to be oldest first, received %v", history)
+		}
+	}
+	if !history[len(history)-1].Equal(agent.StatusCheckIn) {
+		t.Errorf("expected the newest check in %s to be last, received %s", agent.StatusCheckIn, history[len(history)-1])
+	}
+	if _, m = GetCheckInHistory(uuid.NewV4()); !m.Error {
+		t.Error("expected an error for an unknown agent")
+	}
+}
+
+// TestSetNote verifies an agent's note is shown in its information but not in the agents table
+func TestSetNote(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID, WaitTime: "10s", StatusCheckIn: time.Now()}
+	defer delete(agents.Agents, agentID)
+
+	note := "pivot through this box"
+	if m := SetNote(agentID, note); m.Error {
+		t.Fatal(m.Message)
+	}
+	if m := SetNote(uuid.NewV4(), note); !m.Error {
+		t.Error("expected an error setting the note of an unknown agent")
+	}
+
+	rows, m := GetAgentInfo(agentID)
+	if m.Error {
+		t.Fatal(m.Message)
+	}
+	var found bool
+	for _, row := range rows {
+		if row[0] == "Note" {
+			found = true
+			if row[1] != note {
+				t.Errorf("expected the note %q, received %q", note, row[1])
+			}
+		}
+	}
+	if !found {
+		t.Error("the agent information did not contain a Note row")
+	}
+
+	header, agentRows := GetAgentsRows()
+	for _, column := range header {
+		if column == "Note" {
+			t.Error("expected the agents table not to contain a Note column")
+		}
+	}
+	for _, row := range agentRows {
+		for _, column := range row {
+			if column == note {
+				t.Errorf("expected the agents table not to contain the note: %v", row)
+			}
+		}
+	}
+}
+
+// TestCancelJob verifies a single queued job is canceled and that it can't be canceled again
+func TestCancelJob(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID}
+	defer delete(agents.Agents, agentID)
+
+	var jobIDs []string
+	for _, command := range []string{"whoami", "hostname"} {
+		jobID, err := addJob(agentID, "run", []string{command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	if m := CancelJob(agentID, jobIDs[1]); m.Error {
+		t.Fatal(m.Message)
+	}
+	if m := CancelJob(agentID, jobIDs[1]); !m.Error {
+		t.Error("expected an error canceling a job that was already canceled")
+	}
+	if m := CancelJob(agentID, "notAJob"); !m.Error {
+		t.Error("expected an error canceling a job that doesn't exist")
+	}
+	if m := CancelJob(uuid.NewV4(), jobIDs[0]); !m.Error {
+		t.Error("expected an error canceling another agent's job")
+	}
+}
+
+// TestGetQueueDepth verifies the number of jobs waiting to be sent to an agent and that unknown agents are rejected
+func TestGetQueueDepth(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID}
+	defer delete(agents.Agents, agentID)
+
+	if depth, m := GetQueueDepth(agentID); m.Error || depth != 0 {
+		t.Errorf("expected a queue depth of 0 before any jobs were created, received %d: %s", depth, m.Message)
+	}
+	for _, command := range []string{"whoami", "hostname", "pwd"} {
+		if _, err := addJob(agentID, "run", []string{command}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if depth, m := GetQueueDepth(agentID); m.Error || depth != 3 {
+		t.Errorf("expected a queue depth of 3, received %d: %s", depth, m.Message)
+	}
+	if _, err := jobs.Get(agentID); err != nil {
+		t.Fatal(err)
+	}
+	if depth, m := GetQueueDepth(agentID); m.Error || depth != 0 {
+		t.Errorf("expected a queue depth of 0 after the jobs were sent, received %d: %s", depth, m.Message)
+	}
+	if _, m := GetQueueDepth(uuid.NewV4()); !m.Error {
+		t.Error("expected an error for an agent that doesn't exist")
+	}
+}
+
+// TestExecuteShellcodeMemfd verifies space separated hex shellcode is accepted by the memfd method and that a PID is rejected
+func TestExecuteShellcodeMemfd(t *testing.T) {
+	agentID := uuid.NewV4()
+	agents.Agents[agentID] = &agents.Agent{ID: agentID}
+	defer delete(agents.Agents, agentID)
+
+	file := filepath.Join(t.TempDir(), "shellcode.hex")
+	err := ioutil.WriteFile(file, []byte("9090c3"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args  []string
+		error bool
+	}{
+		{[]string{"execute-shellcode", "memfd", "90", "90", "c3"}, false},
+		{[]string{"execute-shellcode", "memfd", "9090c3"}, false},
+		{[]string{"execute-shellcode", "memfd", "0x90", "0x90", "0xc3"}, false},
+		{[]string{"execute-shellcode", "memfd", file}, false},
+		{[]string{"execute-shellcode", "memfd", "1234", file}, true},
+	}
+	for _, test := range tests {
+		m := ExecuteShellcode(agentID, test.args)
+		if m.Error != test.error {
+			t.Errorf("%v: expected error to be %t, received %t: %s", test.args, test.error, m.Error, m.Message)
+		}
+	}
+}
+
+// TestJobRedaction verifies redaction patterns and toggles are set through the API
+func TestJobRedaction(t *testing.T) {
+	pattern := []string{"AKIA[0-9A-Z]{16}"}
+	if m := AddJobRedaction(pattern); m.Error {
+		t.Fatal(m.Message)
+	}
+	defer RemoveJobRedaction(pattern)
+	if m := AddJobRedaction(pattern); !m.Error {
+		t.Error("expected an error adding a redaction pattern that already exists")
+	}
+	if m := AddJobRedaction([]string{"("}); !m.Error {
+		t.Error("expected an error adding a redaction pattern that doesn't compile")
+	}
+	if m := GetJobRedactions(); !strings.Contains(m.Message, pattern[0]) {
+		t.Errorf("expected the redaction patterns to contain %s, received %s", pattern[0], m.Message)
+	}
+
+	if m := SetJobRedaction([]string{"false"}); m.Error {
+		t.Error(m.Message)
+	}
+	if m := SetJobRedaction([]string{"true"}); m.Error {
+		t.Error(m.Message)
+	}
+	if m := SetKeepUnredacted([]string{"maybe"}); !m.Error {
+		t.Error("expected an error for a value that isn't true or false")
+	}
+	if m := SetKeepUnredacted(nil); !m.Error {
+		t.Error("expected an error when no value is provided")
+	}
+	if m := GetUnredactedResults("notAJob"); !m.Error {
+		t.Error("expected an error for a job that doesn't exist")
+	}
+
+	if m := RemoveJobRedaction(pattern); m.Error {
+		t.Fatal(m.Message)
+	}
+	if m := RemoveJobRedaction(pattern); !m.Error {
+		t.Error("expected an error removing a redaction pattern that doesn't exist")
+	}
+}
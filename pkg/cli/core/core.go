@@ -20,6 +20,7 @@ package core
 import (
 	// Standard
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -34,6 +35,7 @@ import (
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg/api/messages"
 	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/server/jobs"
 )
 
 // Prompt is the command line interface prompt object
@@ -132,5 +134,12 @@ func ExecuteCommand(name string, arg []string) {
 func Exit() {
 	color.Red("[!]Quitting...")
 	logging.Server("Shutting down Merlin due to user input")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := jobs.Shutdown(ctx)
+	cancel()
+	if err != nil {
+		color.Red(fmt.Sprintf("[!]%s", err))
+		logging.Server(err.Error())
+	}
 	os.Exit(0)
 }
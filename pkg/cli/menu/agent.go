@@ -54,13 +54,23 @@ func handlerAgent(cmd []string) {
 	case "cd":
 		core.MessageChannel <- agentAPI.CD(agent, cmd)
 	case "clear", "c":
+		if len(cmd) > 1 {
+			core.MessageChannel <- agentAPI.CancelJob(agent, cmd[1])
+			return
+		}
 		core.MessageChannel <- agentAPI.ClearJobs(agent)
+	case "cp":
+		core.MessageChannel <- agentAPI.Copy(agent, cmd)
 	case "download":
 		core.MessageChannel <- agentAPI.Download(agent, cmd)
+	case "downloadDir":
+		core.MessageChannel <- agentAPI.DownloadDir(agent, cmd)
 	case "env":
 		core.MessageChannel <- agentAPI.ENV(agent, cmd)
 	case "execute-assembly", "assembly":
 		go func() { core.MessageChannel <- agentAPI.ExecuteAssembly(agent, cmd) }()
+	case "execute-bof", "bof":
+		core.MessageChannel <- agentAPI.ExecuteBOF(agent, cmd)
 	case "execute-pe", "pe":
 		go func() { core.MessageChannel <- agentAPI.ExecutePE(agent, cmd) }()
 	case "execute-shellcode", "shinject":
@@ -116,7 +126,69 @@ func handlerAgent(cmd []string) {
 		core.MessageChannel <- agentAPI.InvokeAssembly(agent, cmd)
 	case "ja3":
 		core.MessageChannel <- agentAPI.JA3(agent, cmd)
+	case "jitter":
+		if len(cmd) < 2 {
+			core.MessageChannel <- messages.ErrorMessage("not enough arguments provided, a percentage from 0 to 100 must be provided")
+			return
+		}
+		core.MessageChannel <- agentAPI.SetJitter(agent, cmd[1])
 	case "jobs":
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "status" {
+			core.MessageChannel <- agentAPI.GetJobStatus(cmd[2])
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "results" {
+			core.MessageChannel <- agentAPI.GetJobResults(cmd[2])
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "progress" {
+			core.MessageChannel <- agentAPI.GetDownloadProgress(cmd[2])
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "export" {
+			core.MessageChannel <- agentAPI.ExportJobs(agent, cmd[2])
+			return
+		}
+		if len(cmd) > 3 && strings.ToLower(cmd[1]) == "reassign" {
+			id, err := uuid.FromString(cmd[3])
+			if err != nil {
+				core.MessageChannel <- messages.ErrorMessage(fmt.Sprintf("invalid agent ID %s:\r\n%s", cmd[3], err))
+				return
+			}
+			core.MessageChannel <- agentAPI.ReassignJob(cmd[2], id)
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "resend" {
+			core.MessageChannel <- agentAPI.ResendJob(cmd[2])
+			return
+		}
+		if len(cmd) > 1 && strings.ToLower(cmd[1]) == "list" {
+			rows, message := agentAPI.GetJobsForAgentByStatus(agent, cmd[2:])
+			if message.Message != "" {
+				core.MessageChannel <- message
+				return
+			}
+			displayJobTable(rows)
+			return
+		}
+		if len(cmd) > 1 && strings.ToLower(cmd[1]) == "history" {
+			header, rows, message := agentAPI.GetJobHistory(agent)
+			if message.Message != "" {
+				core.MessageChannel <- message
+				return
+			}
+			core.DisplayTable(header, rows)
+			return
+		}
+		if len(cmd) > 1 && strings.ToLower(cmd[1]) == "completed" {
+			rows, message := agentAPI.GetCompletedJobsForAgent(agent)
+			if message.Message != "" {
+				core.MessageChannel <- message
+				return
+			}
+			core.DisplayTable([]string{"ID", "Type", "Status", "Created", "Sent", "Completed", "Exit Code"}, rows)
+			return
+		}
 		jobs, message := agentAPI.GetJobsForAgent(agent)
 		if message.Message != "" {
 			core.MessageChannel <- message
@@ -140,6 +212,8 @@ func handlerAgent(cmd []string) {
 		core.MessageChannel <- agentAPI.MaxRetry(agent, cmd)
 	case "memfd":
 		core.MessageChannel <- agentAPI.MEMFD(agent, cmd)
+	case "mv":
+		core.MessageChannel <- agentAPI.Move(agent, cmd)
 	case "netstat":
 		core.MessageChannel <- agentAPI.Netstat(agent, cmd)
 	case "note":
@@ -154,10 +228,14 @@ func handlerAgent(cmd []string) {
 		core.MessageChannel <- agentAPI.Padding(agent, cmd)
 	case "pipes":
 		core.MessageChannel <- agentAPI.Pipes(agent)
+	case "playbook":
+		core.MessageChannel <- agentAPI.Playbook(agent, cmd)
 	case "printenv":
 		core.MessageChannel <- agentAPI.ENV(agent, []string{"env", "showall"})
+	case "portfwd":
+		core.MessageChannel <- agentAPI.PortForward(agent, cmd)
 	case "ps":
-		core.MessageChannel <- agentAPI.PS(agent)
+		core.MessageChannel <- agentAPI.PS(agent, cmd)
 	case "pwd":
 		core.MessageChannel <- agentAPI.PWD(agent, cmd)
 	case "quit":
@@ -169,13 +247,21 @@ func handlerAgent(cmd []string) {
 		if core.Confirm("Are you sure you want to quit Merlin?") {
 			core.Exit()
 		}
+	case "readmemory":
+		core.MessageChannel <- agentAPI.ReadMemory(agent, cmd)
+	case "registry":
+		core.MessageChannel <- agentAPI.Registry(agent, cmd)
 	case "run", "shell", "exec":
 		core.MessageChannel <- agentAPI.CMD(agent, cmd)
+	case "screenshot":
+		core.MessageChannel <- agentAPI.Screenshot(agent, cmd)
 	case "sessions":
 		header, rows := agentAPI.GetAgentsRows()
 		core.DisplayTable(header, rows)
 	case "sharpgen":
 		go func() { core.MessageChannel <- agentAPI.SharpGen(agent, cmd) }()
+	case "rm":
+		core.MessageChannel <- agentAPI.Delete(agent, cmd)
 	case "sdelete":
 		core.MessageChannel <- agentAPI.SecureDelete(agent, cmd)
 	case "skew":
@@ -216,12 +302,31 @@ func handlerAgent(cmd []string) {
 				Error:   false,
 			}
 		}
+	case "steal_token":
+		core.MessageChannel <- agentAPI.StealToken(agent, cmd)
+	case "tag":
+		if len(cmd) > 2 {
+			switch strings.ToLower(cmd[1]) {
+			case "add":
+				core.MessageChannel <- agentAPI.AddAgentTag(agent, strings.Join(cmd[2:], " "))
+			case "remove":
+				core.MessageChannel <- agentAPI.RemoveAgentTag(agent, strings.Join(cmd[2:], " "))
+			default:
+				core.MessageChannel <- messages.ErrorMessage(fmt.Sprintf("invalid tag command: %s", cmd[1]))
+			}
+		} else {
+			core.MessageChannel <- messages.ErrorMessage("not enough arguments provided, use: tag <add | remove> <tag>")
+		}
+	case "stomp":
+		core.MessageChannel <- agentAPI.Timestomp(agent, cmd)
 	case "touch", "timestomp":
 		core.MessageChannel <- agentAPI.Touch(agent, cmd)
 	case "upload":
 		core.MessageChannel <- agentAPI.Upload(agent, cmd)
 	case "uptime":
 		core.MessageChannel <- agentAPI.Uptime(agent)
+	case "whoami":
+		core.MessageChannel <- agentAPI.Whoami(agent, cmd)
 	default:
 		if len(cmd) > 1 {
 			core.ExecuteCommand(cmd[0], cmd[1:])
@@ -238,7 +343,9 @@ func completerAgent() *readline.PrefixCompleter {
 		readline.PcItem("back"),
 		readline.PcItem("cd"),
 		readline.PcItem("clear"),
+		readline.PcItem("cp"),
 		readline.PcItem("download"),
+		readline.PcItem("downloadDir"),
 		readline.PcItem("env",
 			readline.PcItem("get"),
 			readline.PcItem("set"),
@@ -261,31 +368,61 @@ func completerAgent() *readline.PrefixCompleter {
 			readline.PcItemDynamic(agentListCompleter()),
 		),
 		readline.PcItem("ja3"),
-		readline.PcItem("jobs"),
+		readline.PcItem("jitter"),
+		readline.PcItem("jobs",
+			readline.PcItem("completed"),
+			readline.PcItem("export"),
+			readline.PcItem("history"),
+			readline.PcItem("list"),
+			readline.PcItem("progress"),
+			readline.PcItem("reassign"),
+			readline.PcItem("resend"),
+			readline.PcItem("results"),
+			readline.PcItem("status"),
+		),
 		readline.PcItem("kill"),
 		readline.PcItem("killdate"),
 		readline.PcItem("ls"),
 		readline.PcItem("main"),
 		readline.PcItem("maxretry"),
+		readline.PcItem("mv"),
 		readline.PcItem("note"),
 		readline.PcItem("padding"),
+		readline.PcItem("playbook"),
+		readline.PcItem("portfwd",
+			readline.PcItem("forward"),
+			readline.PcItem("list"),
+			readline.PcItem("reverse"),
+			readline.PcItem("stop"),
+		),
 		readline.PcItem("printenv"),
 		readline.PcItem("pwd"),
 		readline.PcItem("quit"),
+		readline.PcItem("readmemory"),
+		readline.PcItem("rm"),
 		readline.PcItem("run"),
+		readline.PcItem("screenshot"),
 		readline.PcItem("sessions"),
 		readline.PcItem("sdelete"),
 		readline.PcItem("shell"),
 		readline.PcItem("skew"),
 		readline.PcItem("sleep"),
 		readline.PcItem("status"),
+		readline.PcItem("stomp"),
+		readline.PcItem("tag",
+			readline.PcItem("add"),
+			readline.PcItem("remove"),
+		),
+		readline.PcItem("timestomp"),
 		readline.PcItem("touch"),
 		readline.PcItem("upload"),
+		readline.PcItem("whoami"),
 	}
 
 	// Commands only available to Windows agents
 	windows := []readline.PrefixCompleterInterface{
 		readline.PcItem("execute-assembly"),
+		readline.PcItem("execute-bof"),
 		readline.PcItem("execute-pe"),
 		readline.PcItem("execute-shellcode",
 			readline.PcItem("self"),
@@ -298,12 +435,21 @@ func completerAgent() *readline.PrefixCompleter {
 		readline.PcItem("netstat"),
 		readline.PcItem("pipes"),
 		readline.PcItem("ps"),
+		readline.PcItem("registry",
+			readline.PcItem("query"),
+			readline.PcItem("add"),
+			readline.PcItem("delete"),
+		),
 		readline.PcItem("sharpgen"),
+		readline.PcItem("steal_token"),
 		readline.PcItem("uptime"),
 	}
 
 	// Commands only available to Linux agents
 	linux := []readline.PrefixCompleterInterface{
+		readline.PcItem("execute-shellcode",
+			readline.PcItem("memfd"),
+		),
 		readline.PcItem("memfd"),
 	}
 
@@ -328,9 +474,11 @@ func helpAgent() {
 	// Commands available to all agents
 	base := [][]string{
 		{"cd", "Change directories", "cd ../../ OR cd c:\\\\Users"},
-		{"clear", "Clear any UNSENT jobs from the queue", ""},
+		{"clear", "Clear any UNSENT jobs, or only the provided job, from the queue", "clear [job ID]"},
 		{"back", "Return to the main menu", ""},
-		{"download", "Download a file from the agent", "download <remote_file>"},
+		{"cp", "Copy a file on the agent's host", "cp <source> <destination>"},
+		{"download", "Download a file from the agent", "download <remote_file> [compress]"},
+		{"downloadDir", "Download every file in a directory on the agent, recreating the directory", "downloadDir <remote_directory> [compress]"},
 		{"env", "View and modify environment variables", "env <get | set | unset | showall> [variable] [value]"},
 		{"exit", "Instruct the agent to exit and quit running", ""},
 		{"ifconfig", "Displays host network adapter information", ""},
@@ -338,45 +486,59 @@ func helpAgent() {
 		{"interact", "Interact with an agent", ""},
 		{"info", "Display all information about the agent", ""},
 		{"ja3", "Set the agent's JA3 client signature", "ja3 <ja3 signature string>"},
-		{"jobs", "Display all active jobs for the agent", ""},
+		{"jitter", "Set the percentage of the agent's sleep time that it will use to randomize checkin times", "jitter <0-100>"},
+		{"jobs", "Display all active or completed jobs for the agent, the history of every job, the status, results, or download progress of one job, reassign or resend a job, or export the active jobs to a playbook", "jobs [completed | export <JSON file> | history | list [status] | progress <job ID> | reassign <job ID> <agent ID> | resend <job ID> | results <job ID> | status <job ID>]"},
 		{"kill", "Kill a running process by its numerical identifier (pid)", "kill <pid>"},
-		{"killdate", "Set the epoch date/time the agent will quit running", "killdate <epoch date>"},
+		{"killdate", "Set the epoch date/time the agent will quit running", "killdate <epoch date | disable>"},
 		{"ls", "List directory contents", "ls /etc OR ls C:\\\\Users OR ls C:/Users"},
 		{"main", "Return to the main menu", ""},
 		{"maxretry", "Set the maximum amount of times the agent can fail to check in before it dies", "maxretery <number>"},
+		{"mv", "Move or rename a file on the agent's host", "mv <source> <destination>"},
 		{"note", "Add a server-side note to the agent", ""},
 		{"nslookup", "DNS query on host or ip", "nslookup 8.8.8.8"},
 		{"padding", "Set the maximum amount of random data appended to every message", "padding <number>"},
+		{"playbook", "Create, in order, the jobs in a JSON file of objects with a type and args", "playbook <JSON file>"},
+		{"portfwd", "Forward a port through the agent, stop a forward, or list active forwards", "portfwd <forward | reverse | stop | list> [listen address:port] [remote address:port]"},
 		{"printenv", "Print all environment variables. Alias for \"env showall\"", "printenv"},
 		{"pwd", "Display the current working directory", "pwd"},
 		{"quit", "Exit and close the Merlin server", "-y"},
+		{"readmemory", "Read a region of a process's memory", "readmemory <pid> <address> <length>"},
+		{"rm", "Delete files on the agent's host, directories are only deleted with -r --force", "rm [-r --force] <file path> [file path ...]"},
 		{"run", "Execute a program directly, without using a shell", "run ping -c 3 8.8.8.8"},
+		{"screenshot", "Capture an image of the agent's screen and download it to the screenshots directory", "screenshot [display index]"},
 		{"sessions", "Display a table of information about all checked-in agent sessions", ""},
 		{"sdelete", "Securely delete a file", "sdelete <file path>"},
 		{"shell", "Execute a command on the agent using the host's default shell", "shell ping -c 3 8.8.8.8"},
 		{"skew", "Set the amount of skew, or jitter, that an agent will use to checkin", "skew <number>"},
 		{"sleep", "Set the agent's sleep interval using Go time format", "sleep 30s"},
 		{"status", "Print the current status of the agent", ""},
+		{"stomp", "Set the destination file's timestamps to an RFC3339 time or to the source file's timestamps", "stomp <destination> <RFC3339 time | source>"},
+		{"tag", "Add or remove a server-side label for the agent", "tag <add | remove> <tag>"},
 		{"touch", "Match destination file's timestamps with source file (alias timestomp)", "touch <source> <destination>"},
-		{"upload", "Upload a file to the agent", "upload <local_file> <remote_file>"},
+		{"upload", "Upload a file to the agent, --force allows a remote file with path traversal", "upload [--force] <local_file> <remote_file> [compress]"},
+		{"whoami", "Query the agent's current user, groups, and privileges", ""},
 		{"*", "Anything else will be execute on the host operating system", ""},
 	}
 
 	windows := [][]string{
 		{"execute-assembly", "Execute a .NET 4.0 assembly", "execute-assembly <assembly path> [<assembly args> <spawnto path> <spawnto args>]"},
+		{"execute-bof", "Execute a Beacon Object File (BOF) in the agent's process, arguments are i:<int>, s:<short>, z:<string>, Z:<wide string>, or b:<base64>", "execute-bof <bof path> [<type>:<value> ...]"},
 		{"execute-pe", "Execute a Windows PE (EXE)", "execute-pe <pe path> [<pe args> <spawnto path> <spawnto args>]"},
 		{"execute-shellcode", "Execute shellcode", "self, remote <pid>, RtlCreateUserThread <pid>"},
 		{"invoke-assembly", "Invoke, or execute, a .NET assembly that was previously loaded into the agent's process", "<assembly name> <assembly args>"},
 		{"load-assembly", "Load a .NET assembly into the agent's process", "<assembly path> [<assembly name>]"},
 		{"list-assemblies", "List the .NET assemblies that are loaded into the agent's process", ""},
-		{"netstat", "display network connections", "netstat [-p tcp|udp]"},
+		{"netstat", "display network connections", "netstat [-p tcp|udp] [-s state]"},
 		{"pipes", "Enumerate all named pipes", ""},
-		{"ps", "Get a list of running processes", ""},
+		{"ps", "Get a list of running processes, optionally only those whose name contains the filter", "ps [process name]"},
+		{"registry", "Query, add, or delete registry keys and values", "registry <query | add | delete> <key path> [value name] [data type] [data]"},
 		{"sharpgen", "Use SharpGen to compile and execute a .NET assembly", "sharpgen <code> [<spawnto path> <spawnto args>]"},
+		{"steal_token", "Impersonate the token of another process", "steal_token <pid>"},
 		{"uptime", "Retrieve the host's uptime"},
 	}
 
 	linux := [][]string{
+		{"execute-shellcode", "Execute shellcode from an anonymous in-memory file", "memfd <shellcode>"},
 		{"memfd", "Execute Linux file in memory", "<file path> [<arguments>]"},
 	}
 
@@ -479,7 +641,7 @@ func displayJobTable(rows [][]string) {
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetBorder(false)
-	table.SetHeader([]string{"ID", "Command", "Status", "Created", "Sent"})
+	table.SetHeader([]string{"ID", "Command", "Status", "Created", "Sent", "Progress"})
 
 	table.AppendBulk(rows)
 	fmt.Println()
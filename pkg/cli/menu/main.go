@@ -52,6 +52,17 @@ func handlerMain(cmd []string) {
 			case "list":
 				header, rows := agentAPI.GetAgentsRows()
 				core.DisplayTable(header, rows)
+			case "search":
+				if len(cmd) < 3 {
+					core.MessageChannel <- messages.ErrorMessage("not enough arguments provided, a hostname or username to search for must be provided")
+					return
+				}
+				header, rows := agentAPI.SearchAgentsRows(cmd[2])
+				if len(rows) == 0 {
+					core.MessageChannel <- messages.ErrorMessage(fmt.Sprintf("no agents have a hostname or username that contains %s", cmd[2]))
+					return
+				}
+				core.DisplayTable(header, rows)
 			default:
 				core.MessageChannel <- messages.ErrorMessage(fmt.Sprintf("invalid agent command: %s", cmd[1]))
 			}
@@ -80,7 +91,7 @@ func handlerMain(cmd []string) {
 			Error:   false,
 		}
 	case "clear", "c":
-		core.MessageChannel <- agentAPI.ClearJobsCreated()
+		core.MessageChannel <- agentAPI.ClearAllJobs()
 	case "help", "?":
 		helpMain()
 	case "exit", "quit":
@@ -108,6 +119,45 @@ func handlerMain(cmd []string) {
 			interactAgent(cmd[1])
 		}
 	case "jobs":
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "list" {
+			id, err := uuid.FromString(cmd[2])
+			if err != nil {
+				core.MessageChannel <- messages.ErrorMessage(fmt.Sprintf("invalid agent ID %s:\r\n%s", cmd[2], err))
+				return
+			}
+			rows, message := agentAPI.GetJobsForAgentByStatus(id, cmd[3:])
+			if message.Message != "" {
+				core.MessageChannel <- message
+				return
+			}
+			displayJobTable(rows)
+			return
+		}
+		if len(cmd) > 1 && strings.ToLower(cmd[1]) == "events" {
+			header, rows, message := agentAPI.GetJobEvents(cmd[2:])
+			if message.Message != "" {
+				core.MessageChannel <- message
+				return
+			}
+			core.DisplayTable(header, rows)
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "status" {
+			core.MessageChannel <- agentAPI.GetJobStatus(cmd[2])
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "results" {
+			core.MessageChannel <- agentAPI.GetJobResults(cmd[2])
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "unredacted" {
+			core.MessageChannel <- agentAPI.GetUnredactedResults(cmd[2])
+			return
+		}
+		if len(cmd) > 2 && strings.ToLower(cmd[1]) == "resend" {
+			core.MessageChannel <- agentAPI.ResendJob(cmd[2])
+			return
+		}
 		displayAllJobTable(agentAPI.GetJobs())
 	case "listeners":
 		Set(LISTENERS)
@@ -179,6 +229,53 @@ func handlerMain(cmd []string) {
 						Error:   false,
 					}
 				}
+			case "jobttl":
+				core.MessageChannel <- agentAPI.SetJobTTL(cmd[2:])
+			case "jobretention":
+				core.MessageChannel <- agentAPI.SetJobRetention(cmd[2:])
+			case "downloaddir":
+				core.MessageChannel <- agentAPI.SetDownloadDir(cmd[2:])
+			case "downloadnaming":
+				core.MessageChannel <- agentAPI.SetDownloadNaming(cmd[2:])
+			case "resultlimit":
+				core.MessageChannel <- agentAPI.SetJobResultLimit(cmd[2:])
+			case "jsonoutput":
+				core.MessageChannel <- agentAPI.SetJobJSONOutput(cmd[2:])
+			case "denylist":
+				core.MessageChannel <- agentAPI.SetCommandDenylist(cmd[2:])
+			case "allowlist":
+				core.MessageChannel <- agentAPI.SetCommandAllowlist(cmd[2:])
+			case "redact":
+				switch strings.ToLower(cmd[2]) {
+				case "add":
+					core.MessageChannel <- agentAPI.AddJobRedaction(cmd[3:])
+				case "remove":
+					core.MessageChannel <- agentAPI.RemoveJobRedaction(cmd[3:])
+				case "list":
+					core.MessageChannel <- agentAPI.GetJobRedactions()
+				default:
+					core.MessageChannel <- agentAPI.SetJobRedaction(cmd[2:])
+				}
+			case "keepunredacted":
+				core.MessageChannel <- agentAPI.SetKeepUnredacted(cmd[2:])
+			case "paddingcharset":
+				core.MessageChannel <- agentAPI.SetPaddingCharset(cmd[2:])
+			case "paddingdistribution":
+				core.MessageChannel <- agentAPI.SetPaddingDistribution(cmd[2:])
+			case "responsebudget":
+				core.MessageChannel <- agentAPI.SetResponseBudget(cmd[2:])
+			case "queuesize":
+				core.MessageChannel <- agentAPI.SetJobQueueSize(cmd[2:])
+			case "writeretry":
+				core.MessageChannel <- agentAPI.SetWriteRetry(cmd[2:])
+			case "uploadchunksize":
+				core.MessageChannel <- agentAPI.SetUploadChunkSize(cmd[2:])
+			case "maxuploadsize":
+				core.MessageChannel <- agentAPI.SetMaxUploadSize(cmd[2:])
+			case "statusthreshold":
+				core.MessageChannel <- agentAPI.SetStatusThresholds(cmd[2:])
+			case "checkinhistory":
+				core.MessageChannel <- agentAPI.SetCheckInHistorySize(cmd[2:])
 			case "debug":
 				if strings.ToLower(cmd[2]) == "true" {
 					core.Debug = true
@@ -227,6 +324,7 @@ func completerMain() *readline.PrefixCompleter {
 			readline.PcItem("interact",
 				readline.PcItemDynamic(agentListCompleter()),
 			),
+			readline.PcItem("search"),
 		),
 		readline.PcItem("banner"),
 		readline.PcItem("clear"),
@@ -249,7 +347,16 @@ func completerMain() *readline.PrefixCompleter {
 		readline.PcItem("interact",
 			readline.PcItemDynamic(agentListCompleter()),
 		),
-		readline.PcItem("jobs"),
+		readline.PcItem("jobs",
+			readline.PcItem("events"),
+			readline.PcItem("list",
+				readline.PcItemDynamic(agentListCompleter()),
+			),
+			readline.PcItem("resend"),
+			readline.PcItem("results"),
+			readline.PcItem("status"),
+			readline.PcItem("unredacted"),
+		),
 		readline.PcItem("listeners"),
 		readline.PcItem("queue",
 			readline.PcItemDynamic(agentListCompleter()),
@@ -318,12 +425,12 @@ func helpMain() {
 	table.SetHeader([]string{"Command", "Description", "Options"})
 
 	data := [][]string{
-		{"agent", "Interact with agents or list agents", "interact, list"},
+		{"agent", "Interact with agents, list agents, or search for agents by hostname or username", "interact, list, search <term>"},
 		{"banner", "Print the Merlin banner", ""},
 		{"clear", "clears all unset jobs", ""},
 		{"group", "Add, remove, or list groups", "group <add | remove | list] <group>"},
 		{"interact", "Interact with an agent", ""},
-		{"jobs", "Display all unfinished jobs", ""},
+		{"jobs", "Display all unfinished jobs, recent job events, or the status, results, or unredacted results of one job, or resend a job", "jobs [events [limit] | list <agent ID> [status] | resend <job ID> | results <job ID> | status <job ID> | unredacted <job ID>]"},
 		{"listeners", "Move to the listeners menu", ""},
 		{"queue", "queue up commands for one, a group, or unknown agents", "queue <agentID> <command>"},
 		{"quit", "Exit and close the Merlin server", "-y"},
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sync"
 	"time"
 
 	// 3rd Party
@@ -44,6 +45,9 @@ var Verbose = false
 var CurrentDir, _ = os.Getwd()
 var src = rand.NewSource(time.Now().UnixNano())
 
+// srcMutex guards src because a rand.Source is not safe for concurrent use
+var srcMutex = &sync.Mutex{}
+
 // Constants
 const (
 	letterIdxBits = 6                    // 6 bits to represent a letter index
@@ -56,6 +60,8 @@ const (
 func RandStringBytesMaskImprSrc(n int) string {
 	// http://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-golang
 	b := make([]byte, n)
+	srcMutex.Lock()
+	defer srcMutex.Unlock()
 	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
 	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
 		if remain == 0 {
@@ -22,6 +22,7 @@ package jobs
 import (
 	// Standard
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 
 	// 3rd Party
@@ -48,6 +49,10 @@ const (
 	COMPLETE = 4
 	// CANCELED is used to denoted jobs that were cancelled with the "clear" command
 	CANCELED = 5
+	// EXPIRED is used to denote jobs that were not completed before their time to live elapsed
+	EXPIRED = 6
+	// TIMEOUT is used to denote jobs that were sent to the Agent but not answered before their timeout elapsed
+	TIMEOUT = 7
 
 	// To Agent
 
@@ -90,26 +95,98 @@ type Command struct {
 }
 
 // Shellcode is a JSON payload containing shellcode and the method for execution
+// The self and memfd methods execute the shellcode in the agent's own process and never include a PID. The memfd method
+// writes the shellcode to an anonymous in-memory file descriptor (Linux memfd_create) and executes it from there.
+// The remote, rtlcreateuserthread, and userapc methods inject the shellcode into the process identified by PID
 type Shellcode struct {
-	Method string `json:"method"`
+	Method string `json:"method"`        // self, memfd, remote, rtlcreateuserthread, or userapc
 	Bytes  string `json:"bytes"`         // Base64 string of shellcode bytes
 	PID    uint32 `json:"pid,omitempty"` // Process ID for remote injection
 }
 
 // FileTransfer is the JSON payload to transfer files between the server and agent
+// Large files are split into multiple FileTransfer messages that share an ID and are numbered from 0 to TotalChunks-1
+// A directory download returns each file in the directory in its own FileTransfer numbered from 0 to Files-1
 type FileTransfer struct {
 	FileLocation string `json:"dest"`
 	FileBlob     string `json:"blob"`
 	IsDownload   bool   `json:"download"`
+	ID           string `json:"id,omitempty"`          // Unique identifier for a transfer that spans multiple chunks
+	Chunk        int    `json:"chunk,omitempty"`       // The zero based index of this chunk
+	TotalChunks  int    `json:"totalchunks,omitempty"` // The total number of chunks; 0 or 1 means the file is not chunked
+	Hash         string `json:"hash,omitempty"`        // Hex encoded SHA-256 hash of the entire file computed by the sender
+	Compressed   bool   `json:"compressed,omitempty"`  // The FileBlob is gzip compressed before it is base64 encoded
+	Directory    bool   `json:"directory,omitempty"`   // FileLocation is a directory whose files are all downloaded
+	File         int    `json:"file,omitempty"`        // The zero based index of this file in a directory download
+	Files        int    `json:"files,omitempty"`       // The total number of files in a directory download
 }
 
-// Results is a JSON payload that contains the results of an executed command from an agent
+// Results is a JSON payload that contains the results of an executed command from an agent. Results without a Sequence
+// complete the job unless Partial is set. Results with a Sequence can arrive in any order and complete the job once the
+// Final results and every result in front of them have arrived; Partial is derived from Final for them and Final is
+// ignored for results without a Sequence
 type Results struct {
-	Stdout string `json:"stdout"`
-	Stderr string `json:"stderr"`
+	Stdout      string       `json:"stdout"`
+	Stderr      string       `json:"stderr"`
+	Processes   []Process    `json:"processes,omitempty"`   // The processes returned by a ps job
+	Connections []Connection `json:"connections,omitempty"` // The network connections returned by a netstat job
+	Registry    []Registry   `json:"registry,omitempty"`    // The values returned by a registry query job
+	Identity    string       `json:"identity,omitempty"`    // The user the agent runs as after a steal_token or whoami job
+	UserGUID    string       `json:"userguid,omitempty"`    // The identifier of the user the agent runs as from a whoami job
+	Groups      []string     `json:"groups,omitempty"`      // The groups of the user the agent runs as from a whoami job
+	Privileges  []string     `json:"privileges,omitempty"`  // The privileges of the agent's token from a whoami job
+	ExitCode    int          `json:"exitcode,omitempty"`    // The command's exit code, only valid when HasExitCode is true
+	HasExitCode bool         `json:"hasexitcode,omitempty"` // The agent reported the command's exit code
+	Partial     bool         `json:"partial,omitempty"`     // More results for the job will follow, the job is not complete
+	Sequence    int          `json:"sequence,omitempty"`    // The position, from 1, of results that can arrive out of order
+	Final       bool         `json:"final,omitempty"`       // These are the last sequenced results for the job
 }
 
-// String returns the text representation of a message constant
+// UnmarshalJSON decodes JSON encoded Results, treating an exit code sent without HasExitCode as reported so that a
+// missing exit code is unknown
+func (r *Results) UnmarshalJSON(data []byte) error {
+	type results Results
+	v := struct {
+		*results
+		ExitCode *int `json:"exitcode"`
+	}{results: (*results)(r)}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	if v.ExitCode != nil {
+		r.ExitCode = *v.ExitCode
+		r.HasExitCode = true
+	}
+	return nil
+}
+
+// Registry is a single registry value returned in the Results of a registry query job
+type Registry struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// Connection is a single network connection returned in the Results of a netstat job
+type Connection struct {
+	Protocol      string `json:"protocol"`
+	LocalAddress  string `json:"local"`
+	RemoteAddress string `json:"remote"`
+	State         string `json:"state"`
+	PID           int    `json:"pid"`
+}
+
+// Process is a single running process returned in the Results of a ps job
+type Process struct {
+	PID  int    `json:"pid"`
+	PPID int    `json:"ppid"`
+	Name string `json:"name"`
+	User string `json:"user"`
+}
+
+// String returns the text representation of a job type constant
 func String(jobType int) string {
 	switch jobType {
 	case CMD:
@@ -0,0 +1,96 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestString verifies that every job type constant has a text representation
+func TestString(t *testing.T) {
+	jobTypes := []int{CMD, CONTROL, SHELLCODE, NATIVE, FILETRANSFER, OK, MODULE, RESULT, AGENTINFO}
+	for _, jobType := range jobTypes {
+		s := String(jobType)
+		if s == "" || strings.HasPrefix(s, "Invalid") {
+			t.Errorf("job type %d does not have a text representation: %s", jobType, s)
+		}
+	}
+	if !strings.HasPrefix(String(0), "Invalid") {
+		t.Errorf("expected an invalid job type to return an Invalid string, received %s", String(0))
+	}
+}
+
+// TestResultsExitCode verifies the exit code round trips through gob and JSON, and a missing exit code is unknown
+func TestResultsExitCode(t *testing.T) {
+	tests := []Results{
+		{Stdout: "merlin"},
+		{Stdout: "merlin", HasExitCode: true},
+		{Stdout: "merlin", ExitCode: 3, HasExitCode: true},
+		{Stdout: "merlin", ExitCode: -1073741819, HasExitCode: true},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := gob.NewEncoder(&b).Encode(Job{Type: RESULT, Payload: test})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var job Job
+		err = gob.NewDecoder(&b).Decode(&job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := job.Payload.(Results); r.ExitCode != test.ExitCode || r.HasExitCode != test.HasExitCode || r.Stdout != "merlin" {
+			t.Errorf("expected %+v after gob, received %+v", test, r)
+		}
+
+		data, err := json.Marshal(test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r Results
+		err = json.Unmarshal(data, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ExitCode != test.ExitCode || r.HasExitCode != test.HasExitCode || r.Stdout != "merlin" {
+			t.Errorf("expected %+v after JSON, received %+v", test, r)
+		}
+	}
+
+	var r Results
+	err := json.Unmarshal([]byte(`{"stdout": "merlin"}`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.HasExitCode || r.Stdout != "merlin" {
+		t.Errorf("expected a missing exit code to be unknown, received %+v", r)
+	}
+	// An agent that only sends the exit code reported it
+	err = json.Unmarshal([]byte(`{"stdout": "merlin", "exitcode": 0}`), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ExitCode != 0 || !r.HasExitCode {
+		t.Errorf("expected exit code 0, received %+v", r)
+	}
+}
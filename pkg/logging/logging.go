@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	// 3rd Party
@@ -33,7 +34,12 @@ import (
 
 var serverLog *os.File
 
-func init() {
+// serverLogOnce opens the server's log file the first time an entry is written so that importing the package doesn't
+// create the file before core.CurrentDir is set
+var serverLogOnce sync.Once
+
+// openServerLog creates, if needed, and opens the server's log file in the data/log directory
+func openServerLog() {
 
 	// Server Logging
 	if _, err := os.Stat(filepath.Join(core.CurrentDir, "data", "log", "merlinServerLog.txt")); os.IsNotExist(err) {
@@ -66,6 +72,7 @@ func init() {
 
 // Server writes a log entry into the server's log file
 func Server(logMessage string) {
+	serverLogOnce.Do(openServerLog)
 	_, err := serverLog.WriteString(fmt.Sprintf("[%s]%s\r\n", time.Now().UTC().Format(time.RFC3339), logMessage))
 	if err != nil {
 		message("warn", "there was an error writing to the Merlin Server log file")
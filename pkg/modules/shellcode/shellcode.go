@@ -65,13 +65,22 @@ func Parse(options map[string]string) ([]string, error) {
 		}
 	}
 
-	if strings.ToLower(options["method"]) != "self" && options["pid"] == "" {
-		return nil, fmt.Errorf("a valid PID must be provided for any method except self")
+	switch strings.ToLower(options["method"]) {
+	case "self":
+	case "memfd":
+		if options["pid"] != "" {
+			return nil, fmt.Errorf("a PID can not be provided for the memfd method")
+		}
+	default:
+		if options["pid"] == "" {
+			return nil, fmt.Errorf("a valid PID must be provided for any method except self and memfd")
+		}
 	}
 
 	// Verify Method is a valid type
 	switch strings.ToLower(options["method"]) {
 	case "self":
+	case "memfd":
 	case "remote":
 	case "rtlcreateuserthread":
 	case "userapc":
@@ -93,6 +102,8 @@ func GetJob(method string, shellcode string, pid string) ([]string, error) {
 	switch strings.ToLower(method) {
 	case "self":
 		return []string{"shellcode", "self", shellcode}, nil
+	case "memfd":
+		return []string{"shellcode", "memfd", shellcode}, nil
 	case "remote":
 		return []string{"shellcode", "remote", pid, shellcode}, nil
 	case "rtlcreateuserthread":
@@ -129,19 +140,21 @@ func parseHex(str []string) ([]byte, error) {
 	}
 
 	// see if string is prefixed with 0x
-	if hexString[0:2] == "0x" {
+	if strings.HasPrefix(hexString, "0x") {
 		hexString = strings.Replace(hexString, "0x", "", -1)
 		hexString = strings.Replace(hexString, ",", "", -1)
 		hexString = strings.Replace(hexString, " ", "", -1)
 	}
 
 	// see if string is prefixed with \x
-	if hexString[0:2] == "\\x" {
+	if strings.HasPrefix(hexString, "\\x") {
 		hexString = strings.Replace(hexString, "\\x", "", -1)
 		hexString = strings.Replace(hexString, ",", "", -1)
 		hexString = strings.Replace(hexString, " ", "", -1)
 	}
 
+	// Hex bytes can also be separated by spaces without a prefix (e.g., 90 90 c3)
+	hexString = strings.Replace(hexString, " ", "", -1)
 	h, errH := hex.DecodeString(hexString)
 
 	return h, errH
@@ -0,0 +1,106 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// coffMachines contains the COFF header machine types of the x64 and x86 object files a BOF can be compiled to
+var coffMachines = map[uint16]string{0x8664: "x64", 0x14c: "x86"}
+
+// checkBOF validates that the base64 encoded data is a COFF object file, or Beacon Object File (BOF)
+func checkBOF(b64 string) error {
+	if b64 == "" {
+		return fmt.Errorf("the BOF is empty")
+	}
+	b, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return fmt.Errorf("the BOF is not valid base64: %s", err)
+	}
+	// The COFF file header is 20 bytes and starts with the machine type
+	if len(b) < 20 {
+		return fmt.Errorf("the BOF is %d bytes, which is smaller than a COFF file header", len(b))
+	}
+	machine := binary.LittleEndian.Uint16(b[0:2])
+	if _, ok := coffMachines[machine]; !ok {
+		return fmt.Errorf("the BOF has an unsupported COFF machine type 0x%x, expected an x64 or x86 object file", machine)
+	}
+	return nil
+}
+
+// packBOFArgs packs the arguments for a BOF in the format its entry point unpacks them with the Beacon data API. Each
+// argument is a type and a value separated by a colon: i:<int32>, s:<int16>, z:<string>, Z:<wide string>, or
+// b:<base64 binary data>. The packed arguments are prefixed with their total length
+func packBOFArgs(args []string) ([]byte, error) {
+	var packed bytes.Buffer
+	for _, arg := range args {
+		i := strings.Index(arg, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid BOF argument %q, expected <type>:<value> where type is i, s, z, Z, or b", arg)
+		}
+		value := arg[i+1:]
+		switch arg[:i] {
+		case "i":
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BOF int argument %q: %s", value, err)
+			}
+			_ = binary.Write(&packed, binary.LittleEndian, int32(n))
+		case "s":
+			n, err := strconv.ParseInt(value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BOF short argument %q: %s", value, err)
+			}
+			_ = binary.Write(&packed, binary.LittleEndian, int16(n))
+		case "z":
+			// Strings are null terminated
+			data := append([]byte(value), 0)
+			_ = binary.Write(&packed, binary.LittleEndian, uint32(len(data)))
+			packed.Write(data)
+		case "Z":
+			// Wide strings are null terminated UTF-16LE
+			var data bytes.Buffer
+			_ = binary.Write(&data, binary.LittleEndian, append(utf16.Encode([]rune(value)), 0))
+			_ = binary.Write(&packed, binary.LittleEndian, uint32(data.Len()))
+			packed.Write(data.Bytes())
+		case "b":
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BOF binary argument %q, expected base64: %s", value, err)
+			}
+			_ = binary.Write(&packed, binary.LittleEndian, uint32(len(data)))
+			packed.Write(data)
+		default:
+			return nil, fmt.Errorf("unknown BOF argument type %q in %q, expected i, s, z, Z, or b", arg[:i], arg)
+		}
+	}
+	if packed.Len() == 0 {
+		return nil, nil
+	}
+	b := make([]byte, 4, 4+packed.Len())
+	binary.LittleEndian.PutUint32(b, uint32(packed.Len()))
+	return append(b, packed.Bytes()...), nil
+}
@@ -0,0 +1,84 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// defaultResponseBudget is the maximum number of bytes of jobs returned to an agent in one response when its transport
+// doesn't have its own budget. Zero disables the limit
+var defaultResponseBudget = 16777216
+
+// responseBudgets contains the maximum number of bytes of jobs returned to an agent in one response keyed by the
+// agent's transport protocol, such as http3
+var responseBudgets = map[string]int{"http3": 1048576}
+
+// budgetMutex guards the default response budget and the budget for each transport
+var budgetMutex = &sync.RWMutex{}
+
+// SetResponseBudget sets the maximum number of bytes of jobs returned in one response to agents using the transport
+// protocol, such as http3 or h2. An empty protocol sets the budget for transports without their own budget, and a
+// budget of zero disables the limit. Jobs over the budget stay queued for the agent's next check in
+func SetResponseBudget(proto string, size int) error {
+	if size < 0 {
+		return fmt.Errorf("the response budget can not be negative: %d", size)
+	}
+	budgetMutex.Lock()
+	defer budgetMutex.Unlock()
+	if proto == "" {
+		defaultResponseBudget = size
+		return nil
+	}
+	responseBudgets[strings.ToLower(proto)] = size
+	return nil
+}
+
+// responseBudget returns the maximum number of bytes of jobs returned to the agent in one response
+func responseBudget(agentID uuid.UUID) int {
+	var proto string
+	if agent, ok := agents.Agents[agentID]; ok {
+		proto = strings.ToLower(agent.Proto)
+	}
+	budgetMutex.RLock()
+	defer budgetMutex.RUnlock()
+	if size, ok := responseBudgets[proto]; ok {
+		return size
+	}
+	return defaultResponseBudget
+}
+
+// jobSize estimates the number of bytes the job adds to a response by encoding it
+func jobSize(job merlinJob.Job) int {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
@@ -0,0 +1,133 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"sync"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// dependents contains the jobs being held until the job they depend on completes, keyed by the prerequisite job's ID
+var dependents = make(map[string][]merlinJob.Job)
+
+// dependentsMutex guards the dependents map
+var dependentsMutex = &sync.Mutex{}
+
+// AddDependent creates a job that is only added to the Agent's job channel after the job with the dependsOn ID completes
+func AddDependent(agentID uuid.UUID, jobType string, jobArgs []string, dependsOn string) (string, error) {
+	mutex.RLock()
+	j, ok := Jobs[dependsOn]
+	mutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("job %s does not exist", dependsOn)
+	}
+	if !uuid.Equal(j.AgentID, agentID) {
+		return "", fmt.Errorf("job %s does not belong to agent %s", dependsOn, agentID)
+	}
+	if j.Status == merlinJob.CANCELED || j.Status == merlinJob.EXPIRED || j.Status == merlinJob.TIMEOUT {
+		return "", fmt.Errorf("job %s can not be depended on because its status is %s", dependsOn, StatusString(j.Status))
+	}
+	return add(agentID, jobType, jobArgs, dependsOn, "", PRIORITYNORMAL, 0)
+}
+
+// hold keeps the job out of the Agent's job channel until the job with the dependsOn ID completes, queueing it right
+// away if that job has already completed. The job is queued after dependentsMutex is released because queue takes the
+// Agent's lock, which is held while cancelDependents takes dependentsMutex
+func hold(dependsOn string, job merlinJob.Job) error {
+	dependentsMutex.Lock()
+	mutex.RLock()
+	j, ok := Jobs[dependsOn]
+	mutex.RUnlock()
+	if !ok {
+		dependentsMutex.Unlock()
+		return fmt.Errorf("job %s does not exist", dependsOn)
+	}
+	switch j.Status {
+	case merlinJob.COMPLETE:
+		dependentsMutex.Unlock()
+		return queue(job.AgentID, job)
+	case merlinJob.CANCELED, merlinJob.EXPIRED, merlinJob.TIMEOUT:
+		dependentsMutex.Unlock()
+		return fmt.Errorf("job %s can not be depended on because its status is %s", dependsOn, StatusString(j.Status))
+	}
+	dependents[dependsOn] = append(dependents[dependsOn], job)
+	dependentsMutex.Unlock()
+	return nil
+}
+
+// releaseDependents adds the jobs that were waiting on the completed job to their Agent's job channel
+func releaseDependents(jobID string) {
+	dependentsMutex.Lock()
+	held := dependents[jobID]
+	delete(dependents, jobID)
+	dependentsMutex.Unlock()
+
+	for _, job := range held {
+		err := queue(job.AgentID, job)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error queueing job %s after job %s completed:\r\n%s", job.ID, jobID, err))
+		}
+	}
+}
+
+// cancelDependents cancels the jobs waiting on a job that was canceled, expired, or timed out, along with any jobs waiting on them
+func cancelDependents(jobID string) {
+	dependentsMutex.Lock()
+	held := dependents[jobID]
+	delete(dependents, jobID)
+	dependentsMutex.Unlock()
+
+	for _, job := range held {
+		mutex.Lock()
+		j, ok := Jobs[job.ID]
+		if ok && j.Status == merlinJob.CREATED {
+			j.Status = merlinJob.CANCELED
+			j.Completed = time.Now().UTC()
+			Jobs[job.ID] = j
+		}
+		mutex.Unlock()
+		logEvent(job.ID, job.AgentID, merlinJob.CANCELED, fmt.Sprintf("The job it depended on, %s, did not complete", jobID))
+		cancelDependents(job.ID)
+	}
+}
+
+// unhold removes a job that is waiting on another job to complete and returns true if it was found
+func unhold(jobID string) bool {
+	dependentsMutex.Lock()
+	defer dependentsMutex.Unlock()
+	for dependsOn, held := range dependents {
+		for i, job := range held {
+			if job.ID == jobID {
+				dependents[dependsOn] = append(held[:i:i], held[i+1:]...)
+				if len(dependents[dependsOn]) == 0 {
+					delete(dependents, dependsOn)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
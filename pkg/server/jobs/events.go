@@ -0,0 +1,106 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"sync"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+)
+
+// Event is a single job lifecycle event recorded in the event log
+type Event struct {
+	Time    time.Time // Time the event happened
+	JobID   string    // ID of the job the event belongs to
+	AgentID uuid.UUID // ID of the agent the job belongs to
+	Event   string    // The job's new status such as Created, Sent, Complete, Canceled, Expired, or Timeout
+	Detail  string    // Additional information about the event
+}
+
+// events is a ring buffer holding the most recent job lifecycle events across all agents
+var events = make([]Event, 0, 1000)
+
+// eventsNext is the index in the events ring buffer the next event is written to once the buffer is full
+var eventsNext int
+
+// eventsSize is the maximum number of events kept in the event log
+var eventsSize = 1000
+
+// eventsMutex guards the events ring buffer
+var eventsMutex = &sync.Mutex{}
+
+// SetEventLogSize sets the maximum number of job events kept in the event log, keeping the most recent events
+func SetEventLogSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("the event log size must be greater than 0: %d", size)
+	}
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	current := ordered()
+	if len(current) > size {
+		current = current[len(current)-size:]
+	}
+	events = make([]Event, len(current), size)
+	copy(events, current)
+	eventsNext = 0
+	eventsSize = size
+	return nil
+}
+
+// GetEventLog returns, oldest first, up to limit of the most recent job events. A limit of zero returns every event
+func GetEventLog(limit int) []Event {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	e := ordered()
+	if limit > 0 && len(e) > limit {
+		e = e[len(e)-limit:]
+	}
+	return e
+}
+
+// logEvent counts the job's change to the status and appends it to the event log, overwriting the oldest event when
+// the log is full
+func logEvent(jobID string, agentID uuid.UUID, status int, detail string) {
+	countStatus(status)
+	e := Event{
+		Time:    time.Now().UTC(),
+		JobID:   jobID,
+		AgentID: agentID,
+		Event:   StatusString(status),
+		Detail:  detail,
+	}
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	if len(events) < eventsSize {
+		events = append(events, e)
+		return
+	}
+	events[eventsNext] = e
+	eventsNext = (eventsNext + 1) % eventsSize
+}
+
+// ordered returns a copy of the event log in chronological order. The caller must hold eventsMutex
+func ordered() []Event {
+	e := make([]Event, 0, len(events))
+	e = append(e, events[eventsNext:]...)
+	return append(e, events[:eventsNext]...)
+}
@@ -0,0 +1,115 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ForceFlag is a leading job argument that skips a safety check, such as the command denylist and allowlist of run,
+// exec, and shell jobs, or confirms a recursive rm job
+const ForceFlag = "--force"
+
+// denylist contains the base command names that run, exec, and shell jobs are not allowed to execute
+var denylist = make(map[string]bool)
+
+// allowlist contains the only base command names that run, exec, and shell jobs can execute. Empty allows any command
+var allowlist = make(map[string]bool)
+
+// filterMutex guards the denylist and allowlist
+var filterMutex = &sync.RWMutex{}
+
+// SetCommandDenylist replaces the base command names that run, exec, and shell jobs are not allowed to execute. An
+// empty list removes the denylist
+func SetCommandDenylist(commands []string) error {
+	list, err := commandList(commands)
+	if err != nil {
+		return err
+	}
+	filterMutex.Lock()
+	denylist = list
+	filterMutex.Unlock()
+	return nil
+}
+
+// SetCommandAllowlist replaces the only base command names that run, exec, and shell jobs can execute. An empty list
+// allows any command that is not on the denylist
+func SetCommandAllowlist(commands []string) error {
+	list, err := commandList(commands)
+	if err != nil {
+		return err
+	}
+	filterMutex.Lock()
+	allowlist = list
+	filterMutex.Unlock()
+	return nil
+}
+
+// commandList returns the set of lower case base command names for a denylist or allowlist
+func commandList(commands []string) (map[string]bool, error) {
+	list := make(map[string]bool)
+	for _, command := range commands {
+		name := baseCommand(command)
+		if name == "" {
+			return nil, fmt.Errorf("invalid command %q, it does not contain a command name", command)
+		}
+		list[name] = true
+	}
+	return list, nil
+}
+
+// baseCommand returns the lower case name of the command at the start of the command line without its directory or
+// .exe extension so that /bin/rm and C:\Windows\System32\FORMAT.COM match rm and format.com
+func baseCommand(commandLine string) string {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return ""
+	}
+	name := strings.ToLower(fields[0])
+	if i := strings.LastIndexAny(name, "/\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// filterCommand removes the leading ForceFlag from a run, exec, or shell job's arguments or, when it isn't provided,
+// checks the command against the denylist and allowlist
+func filterCommand(jobType string, jobArgs []string) ([]string, error) {
+	if len(jobArgs) > 0 && jobArgs[0] == ForceFlag {
+		return jobArgs[1:], nil
+	}
+	return jobArgs, checkCommand(jobType, strings.Join(jobArgs, " "))
+}
+
+// checkCommand returns an error if the command line's base command is on the denylist or, when there is an allowlist,
+// is not on the allowlist
+func checkCommand(jobType string, commandLine string) error {
+	name := baseCommand(commandLine)
+	filterMutex.RLock()
+	defer filterMutex.RUnlock()
+	if denylist[name] {
+		return fmt.Errorf("the %s command %s is on the command denylist, use %s %s to run it anyway", jobType, name, jobType, ForceFlag)
+	}
+	if len(allowlist) > 0 && !allowlist[name] {
+		return fmt.Errorf("the %s command %s is not on the command allowlist, use %s %s to run it anyway", jobType, name, jobType, ForceFlag)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"sync"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+)
+
+// idempotencyKey identifies an idempotent job request. Keys are scoped to an agent so that the same key can be used
+// for different agents
+type idempotencyKey struct {
+	AgentID uuid.UUID
+	Key     string
+}
+
+// idempotent tracks the job created for an idempotency key
+type idempotent struct {
+	JobID   string    // ID of the job created for the key
+	Created time.Time // Time the key was first used
+}
+
+// idempotencyKeys contains the job created for every idempotency key used within the idempotency window
+var idempotencyKeys = make(map[idempotencyKey]idempotent)
+
+// idempotencyWindow is how long an idempotency key returns the job it created instead of creating a new job
+var idempotencyWindow = 10 * time.Minute
+
+// idempotencyMutex guards the idempotency keys and window. It is held while the job is created so that concurrent
+// requests with the same key only create one job
+var idempotencyMutex = &sync.Mutex{}
+
+// AddIdempotent creates a job like Add unless a job for the agent was already created with the same key within the
+// idempotency window, in which case the existing job's ID is returned. An empty key always creates a new job
+func AddIdempotent(agentID uuid.UUID, jobType string, jobArgs []string, key string) (string, error) {
+	if key == "" {
+		return Add(agentID, jobType, jobArgs)
+	}
+	idempotencyMutex.Lock()
+	defer idempotencyMutex.Unlock()
+
+	now := time.Now().UTC()
+	for k, v := range idempotencyKeys {
+		if now.Sub(v.Created) > idempotencyWindow {
+			delete(idempotencyKeys, k)
+		}
+	}
+
+	k := idempotencyKey{AgentID: agentID, Key: key}
+	if v, ok := idempotencyKeys[k]; ok {
+		// The job may have since been purged with its agent
+		mutex.RLock()
+		_, exists := Jobs[v.JobID]
+		mutex.RUnlock()
+		if exists {
+			return v.JobID, nil
+		}
+	}
+
+	jobID, err := Add(agentID, jobType, jobArgs)
+	if err != nil {
+		return "", err
+	}
+	idempotencyKeys[k] = idempotent{JobID: jobID, Created: now}
+	return jobID, nil
+}
+
+// SetIdempotencyWindow sets how long an idempotency key returns the job it created instead of creating a new job
+func SetIdempotencyWindow(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("the idempotency window must be greater than 0: %s", d)
+	}
+	idempotencyMutex.Lock()
+	idempotencyWindow = d
+	idempotencyMutex.Unlock()
+	return nil
+}
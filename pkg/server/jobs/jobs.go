@@ -19,16 +19,23 @@ package jobs
 
 import (
 	// Standard
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 
 	// 3rd Party
 	"github.com/fatih/color"
@@ -42,39 +49,147 @@ import (
 	"github.com/Ne0nd0g/merlin/pkg/messages"
 )
 
-// JobsChannel contains a map of all instantiated jobs created on the server by each Agent's ID
+// JobsChannel contains a map of all instantiated jobs created on the server by each Agent's ID. It is guarded by mutex
+// and each Agent's lock, so other packages must use this package's functions instead of accessing it directly
 var JobsChannel = make(map[uuid.UUID]chan merlinJob.Job)
 
-// Jobs is a map that contains specific information about an individual job and is embedded in the JobsChannel
+// Jobs is a map that contains specific information about an individual job and is embedded in the JobsChannel. It is
+// guarded by mutex, so other packages must use this package's functions, such as Status, instead of the map itself
 var Jobs = make(map[string]info)
 
-//  info is a structure for holding data for single task assigned to a single agent
+// mutex guards the Jobs and JobsChannel maps. It is only held while accessing the maps and never during file I/O
+var mutex = &sync.RWMutex{}
+
+// ttl is the default amount of time a job can go without completing before it expires. Zero disables expiration
+var ttl time.Duration
+
+// resultLimit is the maximum number of bytes of stdout, and of stderr, stored for each job. Zero disables the limit
+var resultLimit = 1048576
+
+// retention is how long completed, canceled, and expired jobs are kept before they are pruned. Zero keeps them forever
+var retention time.Duration
+
+// queueSize is the maximum number of jobs that can wait in each Agent's channel before Add returns an error
+var queueSize = 100
+
+const (
+	// PRIORITYLOW jobs are sent after every queued normal and high priority job
+	PRIORITYLOW = -1
+	// PRIORITYNORMAL is the default priority of a job
+	PRIORITYNORMAL = 0
+	// PRIORITYHIGH jobs are sent before every queued normal and low priority job
+	PRIORITYHIGH = 1
+)
+
+// RecursiveFlag is a leading argument of an rm job that deletes directories and everything in them
+const RecursiveFlag = "-r"
+
+// randJobID generates a random job ID
+var randJobID = func() string { return core.RandStringBytesMaskImprSrc(10) }
+
+// agentMutex contains a lock for each Agent's job channel so that draining one Agent's queue doesn't block other Agents
+var agentMutex = make(map[uuid.UUID]*sync.Mutex)
+
+// Info is the information about a single job that is passed to OnJobComplete callbacks
+type Info = info
+
+// info is a structure for holding data for single task assigned to a single agent
 type info struct {
-	AgentID   uuid.UUID // ID of the agent the job belong to
-	Type      string    // Type of job
-	Token     uuid.UUID // A unique token for each task that acts like a CSRF token to prevent multiple job messages
-	Status    int       // Use JOB_ constants
-	Chunk     int       // The chunk number
-	Created   time.Time // Time the job was created
-	Sent      time.Time // Time the job was sent to the agent
-	Completed time.Time // Time the job finished
-	Command   string    // The actual command
-}
-
-// Add creates a job and adds it to the specified agent's job channel
-func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
+	AgentID     uuid.UUID          // ID of the agent the job belong to
+	Type        string             // Type of job
+	Token       uuid.UUID          // An HMAC of the agent ID, job ID, and job type that acts like a CSRF token to prevent multiple job messages
+	Status      int                // Use JOB_ constants
+	Chunk       int                // The number of file transfer chunks, or sets of results, received
+	TotalChunks int                // The total number of file transfer chunks
+	Files       int                // The number of files received for a directory download
+	TotalFiles  int                // The total number of files in a directory download
+	Created     time.Time          // Time the job was created
+	Sent        time.Time          // Time the job was sent to the agent
+	Completed   time.Time          // Time the job finished
+	Command     string             // The actual command
+	TTL         time.Duration      // The amount of time the job can go without completing before it expires
+	Result      merlinJob.Results  // The job's output, truncated to the result limit
+	JobType     int                // The job type constant used to rebuild the job when it is resent
+	Payload     interface{}        // The original job payload used to rebuild the job when it is resent
+	OriginalID  string             // ID of the job this job was resent from
+	Session     string             // ID of the interactive shell session the job was created for
+	DependsOn   string             // ID of the job that must complete before this job is added to the Agent's channel
+	Name        string             // The job type the job was created with, such as run or upload
+	Args        []string           // The arguments the job was created with
+	Priority    int                // Jobs with a higher priority are sent to the agent first; use PRIORITY constants
+	Timeout     time.Duration      // How long the agent has to answer the job after it is sent before the job times out
+	Expires     time.Time          // Time the sent job times out, zero when the job doesn't have a timeout
+	Unredacted  *merlinJob.Results // The job's output before redaction, only kept when SetKeepUnredacted is enabled
+}
+
+// entry pairs a job's ID with its information so that a list of jobs can be sorted
+type entry struct {
+	id  string
+	job info
+}
+
+// Add creates a job and adds it to the specified agent's job channel. An optional priority, such as PRIORITYHIGH, sends
+// the job before queued jobs with a lower priority; jobs with the same priority are sent in the order they were added
+func Add(agentID uuid.UUID, jobType string, jobArgs []string, priority ...int) (string, error) {
+	if len(priority) > 1 {
+		return "", fmt.Errorf("expected at most one job priority, received %d", len(priority))
+	}
+	p := PRIORITYNORMAL
+	if len(priority) == 1 {
+		p = priority[0]
+	}
+	return add(agentID, jobType, jobArgs, "", "", p, 0)
+}
+
+// AddWithTimeout creates a job like Add that times out if the agent doesn't answer it within timeout of it being sent.
+// Expire marks the job with the TIMEOUT status once the timeout has elapsed
+func AddWithTimeout(agentID uuid.UUID, jobType string, jobArgs []string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return "", fmt.Errorf("the job timeout must be greater than 0: %s", timeout)
+	}
+	return add(agentID, jobType, jobArgs, "", "", PRIORITYNORMAL, timeout)
+}
+
+// add creates a job with the priority and adds it to the specified agent's job channel or, when dependsOn is not
+// empty, holds it until the job with that ID completes. A job created for an interactive shell session is tagged with
+// the session's ID before it is queued
+func add(agentID uuid.UUID, jobType string, jobArgs []string, dependsOn string, session string, priority int, timeout time.Duration) (string, error) {
 	// TODO turn this into a method of the agent struct
 	if core.Debug {
 		message("debug", fmt.Sprintf("In jobs.Job function for agent: %s", agentID.String()))
 		message("debug", fmt.Sprintf("In jobs.Add function for type: %s, arguments: %v", jobType, jobType))
 	}
 
+	mutex.RLock()
+	stopped := shuttingDown
+	mutex.RUnlock()
+	if stopped {
+		return "", fmt.Errorf("the server is shutting down and is not accepting new jobs")
+	}
+
+	// If the Agent is set to broadcast identifier for ALL agents
+	if agentID.String() == "ffffffff-ffff-ffff-ffff-ffffffffffff" {
+		if dependsOn != "" {
+			return "", fmt.Errorf("a job that depends on job %s must be created for a single agent", dependsOn)
+		}
+		if timeout > 0 {
+			return "", fmt.Errorf("a job with a timeout must be created for a single agent")
+		}
+		jobIDs, err := AddAll(jobType, jobArgs)
+		if err != nil {
+			return "", err
+		}
+		return jobIDs[len(jobIDs)-1], nil
+	}
+
 	agent, ok := agents.Agents[agentID]
 	//if !ok {
 	//	return "", fmt.Errorf("%s is not a valid agent", agentID)
 	//}
 
 	var job merlinJob.Job
+	// source is the file that a chunked upload is read from
+	var source *upload
 
 	switch jobType {
 	case "agentInfo":
@@ -83,6 +198,15 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			Command: "agentInfo",
 		}
 	case "download":
+		// Args[0] = the file on the agent to download
+		// Args[1] = optionally, "compress" to have the agent gzip compress the file before sending it
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		compressed, err := checkCompress(jobType, jobArgs[1:])
+		if err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.FILETRANSFER
 		if ok {
 			agent.Log(fmt.Sprintf("Downloading file from agent at %s\n", jobArgs[0]))
@@ -91,8 +215,56 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		p := merlinJob.FileTransfer{
 			FileLocation: jobArgs[0],
 			IsDownload:   false,
+			Compressed:   compressed,
+		}
+		job.Payload = p
+	case "downloadDir":
+		// Args[0] = the directory on the agent to download
+		// Args[1] = optionally, "compress" to have the agent gzip compress each file before sending it
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		if err := checkRemoteDir(jobArgs[0]); err != nil {
+			return "", err
+		}
+		compressed, err := checkCompress(jobType, jobArgs[1:])
+		if err != nil {
+			return "", err
+		}
+		job.Type = merlinJob.FILETRANSFER
+		if ok {
+			agent.Log(fmt.Sprintf("Downloading directory from agent at %s\n", jobArgs[0]))
+		}
+
+		p := merlinJob.FileTransfer{
+			FileLocation: jobArgs[0],
+			IsDownload:   false,
+			Compressed:   compressed,
+			Directory:    true,
 		}
 		job.Payload = p
+	case "bof":
+		// Args[0] = the base64 encoded BOF, Args[1:] = the BOF's arguments as <type>:<value>
+		// The BOF's output is returned as the job's Results
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		if err := checkBOF(jobArgs[0]); err != nil {
+			return "", err
+		}
+		packed, err := packBOFArgs(jobArgs[1:])
+		if err != nil {
+			return "", err
+		}
+		args := []string{jobArgs[0]}
+		if packed != nil {
+			args = append(args, base64.StdEncoding.EncodeToString(packed))
+		}
+		job.Type = merlinJob.MODULE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    args,
+		}
 	case "cd":
 		job.Type = merlinJob.NATIVE
 		p := merlinJob.Command{
@@ -100,6 +272,19 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			Args:    jobArgs[0:],
 		}
 		job.Payload = p
+	case "cp", "mv":
+		// Args[0] = the source file, Args[1] = the destination file
+		if err := checkArgs(jobType, jobArgs, 2); err != nil {
+			return "", err
+		}
+		if len(jobArgs) > 2 {
+			return "", fmt.Errorf("expected 2 arguments for the %s command, received %d", jobType, len(jobArgs))
+		}
+		job.Type = merlinJob.NATIVE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    jobArgs[0:2],
+		}
 	case "CreateProcess":
 		job.Type = merlinJob.MODULE
 		p := merlinJob.Command{
@@ -108,12 +293,36 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		}
 		job.Payload = p
 	case "env":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		// Only send the arguments the subcommand uses so that a single named variable is requested
+		var args []string
+		switch strings.ToLower(jobArgs[0]) {
+		case "showall":
+			args = jobArgs[:1]
+		case "get", "unset":
+			if err := checkArgs(jobType, jobArgs, 2); err != nil {
+				return "", err
+			}
+			args = jobArgs[:2]
+		case "set":
+			if err := checkArgs(jobType, jobArgs, 3); err != nil {
+				return "", err
+			}
+			args = jobArgs[:3]
+		default:
+			return "", fmt.Errorf("unknown env subcommand %s, expected get, set, unset, or showall", jobArgs[0])
+		}
 		job.Type = merlinJob.NATIVE
 		job.Payload = merlinJob.Command{
 			Command: jobType,
-			Args:    jobArgs,
+			Args:    args,
 		}
 	case "exit":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0], // TODO, this should be in jobType position
@@ -140,6 +349,9 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			Args:    append([]string{jobType}, jobArgs...),
 		}
 	case "ja3":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0],
@@ -149,7 +361,24 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			p.Args = jobArgs[1:]
 		}
 		job.Payload = p
+	case "jitter":
+		// Args[0] = the percentage, 0 to 100, of the agent's sleep time that its check ins are randomized by
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		percent, err := strconv.Atoi(jobArgs[0])
+		if err != nil || percent < 0 || percent > 100 {
+			return "", fmt.Errorf("invalid jitter percentage %q, expected a number from 0 to 100", jobArgs[0])
+		}
+		job.Type = merlinJob.CONTROL
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    []string{strconv.Itoa(percent)},
+		}
 	case "killdate":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0],
@@ -219,6 +448,9 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		}
 		job.Payload = p
 	case "maxretry":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0], // TODO This should be in the jobType postion
@@ -247,6 +479,21 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			Command: jobType,
 			Args:    append([]string{b}, jobArgs[1:]...),
 		}
+	case "ReadMemory":
+		// Args[0] = the target process ID, Args[1] = the base address, Args[2] = the number of bytes to read
+		// The agent returns the bytes it read as a Base64 encoded string in the stdout of the job's Results
+		if err := checkArgs(jobType, jobArgs, 3); err != nil {
+			return "", err
+		}
+		pid, address, length, err := parseMemoryRegion(jobArgs[0], jobArgs[1], jobArgs[2])
+		if err != nil {
+			return "", err
+		}
+		job.Type = merlinJob.MODULE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    []string{strconv.FormatUint(uint64(pid), 10), fmt.Sprintf("0x%x", address), strconv.FormatUint(length, 10)},
+		}
 	case "Minidump":
 		job.Type = merlinJob.MODULE
 		p := merlinJob.Command{
@@ -254,20 +501,48 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			Args:    jobArgs,
 		}
 		job.Payload = p
+	case "registry":
+		// Args[0] = query, add, or delete
+		// Args[1] = the key path starting with the hive such as HKLM\SOFTWARE\Microsoft
+		// Args[2] = the value name, optional for query and delete
+		// Args[3] = the value's data type for add
+		// Args[4] = the value's data for add
+		// The agent returns the values of a query in the Registry field of the job's Results
+		args, err := checkRegistryArgs(jobArgs)
+		if err != nil {
+			return "", err
+		}
+		job.Type = merlinJob.MODULE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    args,
+		}
 	case "netstat":
+		// Args = [netstat] [-p tcp|udp] [-s state]
+		// The agent returns the matching connections in the Connections field of the job's Results
+		args, err := checkNetstatArgs(jobArgs)
+		if err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.MODULE
 		p := merlinJob.Command{
 			Command: jobType,
-			Args:    jobArgs,
+			Args:    args,
 		}
 		job.Payload = p
 	case "nslookup":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.NATIVE
 		job.Payload = merlinJob.Command{
 			Command: jobType,
 			Args:    jobArgs,
 		}
 	case "padding":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0],
@@ -284,26 +559,118 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		}
 		job.Payload = p
 	case "ps":
+		// Args[0] = an optional case-insensitive process name filter
+		// The agent returns the matching processes in the Processes field of the job's Results
 		job.Type = merlinJob.MODULE
 		p := merlinJob.Command{
 			Command: "ps",
 		}
+		if len(jobArgs) > 0 {
+			if err := checkProcessFilter(jobArgs[0]); err != nil {
+				return "", err
+			}
+			p.Args = jobArgs[:1]
+		}
 		job.Payload = p
+	case "portfwd":
+		// The agent receives one of:
+		//   forward <listen address:port> <remote address:port> to listen on the agent and relay to the remote address
+		//   reverse <listen address:port> <remote address:port> to listen on the agent and relay back through the server
+		//   to the remote address
+		//   stop <listen address:port> to tear down an active forward
+		//   list to return the agent's active forwards
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		var args []string
+		switch strings.ToLower(jobArgs[0]) {
+		case "forward", "reverse":
+			if err := checkArgs(jobType, jobArgs, 3); err != nil {
+				return "", err
+			}
+			for _, address := range jobArgs[1:3] {
+				if err := checkAddress(address); err != nil {
+					return "", err
+				}
+			}
+			args = []string{strings.ToLower(jobArgs[0]), jobArgs[1], jobArgs[2]}
+		case "stop":
+			if err := checkArgs(jobType, jobArgs, 2); err != nil {
+				return "", err
+			}
+			if err := checkAddress(jobArgs[1]); err != nil {
+				return "", err
+			}
+			args = []string{"stop", jobArgs[1]}
+		case "list":
+			args = []string{"list"}
+		default:
+			return "", fmt.Errorf("unknown portfwd subcommand %s, expected forward, reverse, stop, or list", jobArgs[0])
+		}
+		job.Type = merlinJob.CONTROL
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    args,
+		}
 	case "pwd":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.NATIVE
 		p := merlinJob.Command{
 			Command: jobArgs[0], // TODO This should be in the jobType position
 		}
 		job.Payload = p
 	case "run", "exec":
+		// A leading ForceFlag skips the command denylist and allowlist
+		args, err := filterCommand(jobType, jobArgs)
+		if err != nil {
+			return "", err
+		}
+		if err := checkArgs(jobType, args, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CMD
 		payload := merlinJob.Command{
-			Command: jobArgs[0],
+			Command: args[0],
 		}
-		if len(jobArgs) > 1 {
-			payload.Args = jobArgs[1:]
+		if len(args) > 1 {
+			payload.Args = args[1:]
 		}
 		job.Payload = payload
+	case "steal_token":
+		// Args[0] = the ID of the process whose token the agent impersonates
+		// The agent returns the identity it runs as in the Identity field of the job's Results
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
+		pid, err := strconv.ParseUint(jobArgs[0], 10, 32)
+		if err != nil || pid == 0 {
+			return "", fmt.Errorf("invalid PID %q for the %s command, expected a process ID greater than 0", jobArgs[0], jobType)
+		}
+		job.Type = merlinJob.MODULE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    []string{strconv.FormatUint(pid, 10)},
+		}
+	case "whoami":
+		// The agent returns its current security context in the Identity, UserGUID, Groups, and Privileges fields of the
+		// job's Results
+		job.Type = merlinJob.NATIVE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+		}
+	case "rm":
+		// Args = an optional RecursiveFlag, which requires a ForceFlag, followed by one or more files or directories
+		args, err := checkRemove(jobArgs)
+		if err != nil {
+			return "", err
+		}
+		job.Type = merlinJob.NATIVE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    args,
+		}
 	case "sdelete":
 		job.Type = merlinJob.NATIVE
 		job.Payload = merlinJob.Command{
@@ -311,30 +678,76 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			Args:    jobArgs,
 		}
 	case "shell":
+		// A leading ForceFlag skips the command denylist and allowlist
+		args, err := filterCommand(jobType, jobArgs)
+		if err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CMD
 		payload := merlinJob.Command{
 			Command: jobType,
-			Args:    jobArgs,
+			Args:    args,
 		}
 		job.Payload = payload
+	case "screenshot":
+		// Args[0] = the optional index of the display to capture
+		// The agent returns the image as a file transfer with its SHA-256 hash that is written to the screenshots directory
+		var args []string
+		if len(jobArgs) > 0 {
+			display, err := strconv.Atoi(jobArgs[0])
+			if err != nil || display < 0 {
+				return "", fmt.Errorf("invalid display index %q, expected a number greater than or equal to 0", jobArgs[0])
+			}
+			args = []string{strconv.Itoa(display)}
+		}
+		job.Type = merlinJob.MODULE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    args,
+		}
 	case "shellcode":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.SHELLCODE
 		payload := merlinJob.Shellcode{
 			Method: jobArgs[0],
 		}
 
 		if payload.Method == "self" {
+			if err := checkArgs(jobType, jobArgs, 2); err != nil {
+				return "", err
+			}
+			payload.Bytes = jobArgs[1]
+		} else if payload.Method == "memfd" {
+			if err := checkArgs(jobType, jobArgs, 2); err != nil {
+				return "", err
+			}
+			if len(jobArgs) > 2 {
+				return "", fmt.Errorf("the memfd shellcode method does not accept a PID, expected 2 arguments and received %d", len(jobArgs))
+			}
 			payload.Bytes = jobArgs[1]
 		} else if payload.Method == "remote" || payload.Method == "rtlcreateuserthread" || payload.Method == "userapc" {
-			i, err := strconv.Atoi(jobArgs[1])
-			if err != nil {
+			if err := checkArgs(jobType, jobArgs, 3); err != nil {
 				return "", err
 			}
+			i, err := strconv.ParseUint(jobArgs[1], 10, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid PID %q for method %s: %s", jobArgs[1], payload.Method, err)
+			}
 			payload.PID = uint32(i)
 			payload.Bytes = jobArgs[2]
+		} else {
+			return "", fmt.Errorf("unknown shellcode method %s, expected self, memfd, remote, rtlcreateuserthread, or userapc", payload.Method)
+		}
+		if err := checkShellcode(payload.Bytes); err != nil {
+			return "", fmt.Errorf("invalid shellcode for method %s: %s", payload.Method, err)
 		}
 		job.Payload = payload
 	case "skew":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0],
@@ -345,6 +758,9 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		}
 		job.Payload = p
 	case "sleep":
+		if err := checkArgs(jobType, jobArgs, 1); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.CONTROL
 		p := merlinJob.Command{
 			Command: jobArgs[0],
@@ -354,7 +770,26 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 			p.Args = jobArgs[1:]
 		}
 		job.Payload = p
+	case "timestomp":
+		// Sent to the agent as the timestomp native command with Args[0] = the destination file and Args[1] = the
+		// RFC3339 time to set the file's access and modify times to
+		if err := checkArgs(jobType, jobArgs, 2); err != nil {
+			return "", err
+		}
+		if _, err := time.Parse(time.RFC3339, jobArgs[1]); err != nil {
+			return "", fmt.Errorf("there was an error parsing %s as an RFC3339 time:\r\n%s", jobArgs[1], err)
+		}
+		job.Type = merlinJob.NATIVE
+		job.Payload = merlinJob.Command{
+			Command: jobType,
+			Args:    jobArgs[0:2],
+		}
 	case "touch":
+		// Sent to the agent as the touch native command with every argument, Args[0] = "touch", Args[1] = the source
+		// file to copy times from, Args[2] = the destination file
+		if err := checkArgs(jobType, jobArgs, 3); err != nil {
+			return "", err
+		}
 		job.Type = merlinJob.NATIVE
 		job.Payload = merlinJob.Command{
 			Command: jobType,
@@ -362,31 +797,100 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		}
 	case "upload":
 		job.Type = merlinJob.FILETRANSFER
-		if len(jobArgs) < 2 {
-			return "", fmt.Errorf("expected 2 arguments for upload command, received %d", len(jobArgs))
+		// A leading ForceFlag allows a destination that contains path traversal
+		args := jobArgs
+		force := len(args) > 0 && args[0] == ForceFlag
+		if force {
+			args = args[1:]
+		}
+		if len(args) < 2 {
+			return "", fmt.Errorf("expected 2 arguments for upload command, received %d", len(args))
+		}
+		// Args[2] = optionally, "compress" to gzip compress the file, or each chunk, before it is sent to the agent
+		compressed, err := checkCompress(jobType, args[2:])
+		if err != nil {
+			return "", err
+		}
+		relative, err := checkDestination(args[1], force)
+		if err != nil {
+			return "", err
+		}
+		if relative {
+			warning := fmt.Sprintf("The upload destination %s is a relative path and will be written relative to the agent's current directory", args[1])
+			message("warn", warning)
+			if ok {
+				agent.Log(warning)
+			}
+		}
+		f, errStat := os.Stat(args[0])
+		if errStat != nil {
+			return "", fmt.Errorf("there was an error reading %s: %v", args[0], errStat)
+		}
+		if err = checkUploadSize(args[0], f.Size()); err != nil {
+			return "", err
+		}
+		// Reading the file is canceled if the server shuts down
+		ctx, done := fileOperation(args[0])
+		defer done()
+		// Large files are read from disk one chunk at a time as the chunks are sent to the agent
+		chunkSize := getUploadChunkSize()
+		if f.Size() > chunkSize {
+			size, fileHash, errHash := hashFile(ctx, args[0])
+			if errHash != nil {
+				return "", errHash
+			}
+			source = &upload{
+				Source:    args[0],
+				Size:      size,
+				ChunkSize: chunkSize,
+			}
+			if ok {
+				agent.Log(fmt.Sprintf("Uploading file from server at %s of size %d bytes and SHA-256: %x to agent at %s in %d chunks",
+					args[0],
+					size,
+					fileHash,
+					args[1],
+					source.totalChunks()))
+			}
+			job.Payload = merlinJob.FileTransfer{
+				FileLocation: args[1],
+				IsDownload:   true,
+				Chunk:        0,
+				TotalChunks:  source.totalChunks(),
+				Hash:         hex.EncodeToString(fileHash),
+				Compressed:   compressed,
+			}
+			break
 		}
-		uploadFile, uploadFileErr := ioutil.ReadFile(jobArgs[0])
+		uploadFile, uploadFileErr := readContext(ctx, args[0])
 		if uploadFileErr != nil {
 			// TODO send "ServerOK"
 			return "", fmt.Errorf("there was an error reading %s: %v", merlinJob.String(job.Type), uploadFileErr)
 		}
 		fileHash := sha256.New()
-		_, err := io.WriteString(fileHash, string(uploadFile))
+		_, err = io.WriteString(fileHash, string(uploadFile))
 		if err != nil {
 			message("warn", fmt.Sprintf("There was an error generating file hash:\r\n%s", err.Error()))
 		}
 		if ok {
 			agent.Log(fmt.Sprintf("Uploading file from server at %s of size %d bytes and SHA-256: %x to agent at %s",
-				jobArgs[0],
+				args[0],
 				len(uploadFile),
 				fileHash.Sum(nil),
-				jobArgs[1]))
+				args[1]))
 		}
 
+		if compressed {
+			uploadFile, err = compress(uploadFile)
+			if err != nil {
+				return "", err
+			}
+		}
 		p := merlinJob.FileTransfer{
-			FileLocation: jobArgs[1],
-			FileBlob:     base64.StdEncoding.EncodeToString([]byte(uploadFile)),
+			FileLocation: args[1],
+			FileBlob:     base64.StdEncoding.EncodeToString(uploadFile),
 			IsDownload:   true,
+			Compressed:   compressed,
 		}
 		job.Payload = p
 	case "uptime":
@@ -399,166 +903,649 @@ func Add(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
 		return "", fmt.Errorf("invalid job type: %d", job.Type)
 	}
 
-	// If the Agent is set to broadcast identifier for ALL agents
-	if agentID.String() == "ffffffff-ffff-ffff-ffff-ffffffffffff" {
-		if len(agents.Agents) <= 0 {
-			return "", fmt.Errorf("there are 0 available agents, no jobs were created")
-		}
-		for a := range agents.Agents {
-			// Fill out remaining job fields
-			token := uuid.NewV4()
-			job.ID = core.RandStringBytesMaskImprSrc(10)
-			job.Token = token
-			job.AgentID = a
-			// Add job to the channel
-			_, k := JobsChannel[agentID]
-			if !k {
-				JobsChannel[agentID] = make(chan merlinJob.Job, 100)
-			}
-			JobsChannel[agentID] <- job
-			//agents.Agents[a].JobChannel <- job
-			// Add job to the list
-			Jobs[job.ID] = info{
-				AgentID: a,
-				Token:   token,
-				Type:    merlinJob.String(job.Type),
-				Status:  merlinJob.CREATED,
-				Created: time.Now().UTC(),
-				Command: jobType + " " + strings.Join(jobArgs, " "),
-			}
-			// Log the job
-			if ok {
-				agent.Log(fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Args:%s",
-					messages.String(job.Type),
-					job.ID,
-					"Created",
-					jobArgs))
-			}
-		}
+	// A single Agent
+	job.AgentID = agentID
+	// Add job to the list before the channel so that it is known when the agent retrieves it
+	mutex.Lock()
+	job.ID = newJobID()
+	token, err := jobToken(agentID, job.ID, job.Type)
+	if err != nil {
+		mutex.Unlock()
+		return "", err
+	}
+	job.Token = token
+	Jobs[job.ID] = info{
+		AgentID:   agentID,
+		Token:     token,
+		Type:      merlinJob.String(job.Type),
+		Status:    merlinJob.CREATED,
+		Created:   time.Now().UTC(),
+		Command:   jobType + " " + strings.Join(jobArgs, " "),
+		TTL:       ttl,
+		JobType:   job.Type,
+		Payload:   job.Payload,
+		DependsOn: dependsOn,
+		Session:   session,
+		Name:      jobType,
+		Args:      jobArgs,
+		Priority:  priority,
+		Timeout:   timeout,
+	}
+	if source != nil {
+		j := Jobs[job.ID]
+		j.TotalChunks = source.totalChunks()
+		Jobs[job.ID] = j
+	}
+	mutex.Unlock()
+	if source != nil {
+		setUpload(job.ID, *source)
+	}
+	// Add job to the channel, or hold it until the job it depends on completes
+	if dependsOn != "" {
+		err = hold(dependsOn, job)
 	} else {
-		// A single Agent
-		token := uuid.NewV4()
-		job.Token = token
-		job.ID = core.RandStringBytesMaskImprSrc(10)
-		job.AgentID = agentID
-		// Add job to the channel
-		_, k := JobsChannel[agentID]
-		if !k {
-			JobsChannel[agentID] = make(chan merlinJob.Job, 100)
-		}
-		JobsChannel[agentID] <- job
-		// Add job to the list
-		Jobs[job.ID] = info{
-			AgentID: agentID,
-			Token:   token,
-			Type:    merlinJob.String(job.Type),
-			Status:  merlinJob.CREATED,
-			Created: time.Now().UTC(),
-			Command: jobType + " " + strings.Join(jobArgs, " "),
-		}
-		// Log the job
-		if ok {
-			agent.Log(fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Args:%s",
-				messages.String(job.Type),
-				job.ID,
-				"Created",
-				jobArgs))
-		}
-
+		err = queue(agentID, job)
+	}
+	if err != nil {
+		discard(job.ID)
+		return "", err
+	}
+	logEvent(job.ID, agentID, merlinJob.CREATED, jobType+" "+strings.Join(jobArgs, " "))
+	// Log the job
+	if ok {
+		agent.Log(fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Args:%s",
+			merlinJob.String(job.Type),
+			job.ID,
+			"Created",
+			jobArgs))
 	}
 	return job.ID, nil
 }
 
-// Clear removes any jobs the queue that have been created, but NOT sent to the agent
-func Clear(agentID uuid.UUID) error {
-	if core.Debug {
-		message("debug", "Entering into jobs.Clear() function...")
+// AddAll creates an independent job, with its own ID and token, for every agent and returns the ID of each created job
+func AddAll(jobType string, jobArgs []string) ([]string, error) {
+	if len(agents.Agents) <= 0 {
+		return nil, fmt.Errorf("there are 0 available agents, no jobs were created")
 	}
+	var agentIDs []uuid.UUID
+	for a := range agents.Agents {
+		agentIDs = append(agentIDs, a)
+	}
+	return addEach(agentIDs, jobType, jobArgs)
+}
 
-	//_, ok := agents.Agents[agentID]
-	//if !ok {
-	//	return fmt.Errorf("%s is not a valid agent", agentID)
-	//}
-
-	// Empty the job channel
-	jobChannel, k := JobsChannel[agentID]
-	if !k {
-		// There was not a jobs channel for this agent
-		return nil
+// AddToGroup creates an independent job for every agent in the group and returns the ID of each created job
+func AddToGroup(group string, jobType string, jobArgs []string) ([]string, error) {
+	members, err := agents.GroupMembers(group)
+	if err != nil {
+		return nil, err
 	}
-	jobLength := len(jobChannel)
-	if jobLength > 0 {
-		for i := 0; i < jobLength; i++ {
-			job := <-jobChannel
-			// Update Job Info structure
-			j, ok := Jobs[job.ID]
-			if ok {
-				j.Status = merlinJob.CANCELED
-				Jobs[job.ID] = j
-			} else {
-				return fmt.Errorf("invalid job %s for agent %s", job.ID, agentID)
-			}
-			if core.Debug {
-				message("debug", fmt.Sprintf("Channel command string: %+v", job))
-				message("debug", fmt.Sprintf("Job type: %s", messages.String(job.Type)))
-			}
+
+	var agentIDs []uuid.UUID
+	for _, member := range members {
+		// The "all" group contains the broadcast identifier instead of each agent
+		if member.String() == "ffffffff-ffff-ffff-ffff-ffffffffffff" {
+			return AddAll(jobType, jobArgs)
 		}
+		agentIDs = append(agentIDs, member)
 	}
-	return nil
+	return addEach(agentIDs, jobType, jobArgs)
 }
 
-// ClearCreated removes all unsent jobs across all agents
-func ClearCreated() error {
-	if core.Debug {
-		message("debug", "Entering into jobs.Clear() function...")
+// AddToPlatform creates an independent job for every agent running on the platform, such as windows, and returns the
+// ID of each created job. The platform is matched without case against the agent's platform or, when it contains a
+// slash, against the agent's platform and architecture such as linux/amd64
+func AddToPlatform(platform string, jobType string, jobArgs []string) ([]string, error) {
+	var agentIDs []uuid.UUID
+	for id, agent := range agents.Agents {
+		if strings.EqualFold(agent.Platform, platform) || strings.EqualFold(agent.Platform+"/"+agent.Architecture, platform) {
+			agentIDs = append(agentIDs, id)
+		}
 	}
-	for id := range JobsChannel {
-		err := Clear(id)
+	if len(agentIDs) == 0 {
+		return nil, fmt.Errorf("there are 0 agents running on the %s platform, no jobs were created", platform)
+	}
+	// Create the jobs in the same order every time
+	sort.Slice(agentIDs, func(i, j int) bool { return agentIDs[i].String() < agentIDs[j].String() })
+	return addEach(agentIDs, jobType, jobArgs)
+}
+
+// addEach creates an independent job, with its own ID and token, for each agent and returns the ID of each created job
+func addEach(agentIDs []uuid.UUID, jobType string, jobArgs []string) ([]string, error) {
+	var jobIDs []string
+	for _, a := range agentIDs {
+		jobID, err := Add(a, jobType, jobArgs)
 		if err != nil {
-			return err
+			return jobIDs, err
 		}
+		jobIDs = append(jobIDs, jobID)
 	}
-	return nil
+	return jobIDs, nil
 }
 
-// Get returns a list of jobs that need to be sent to the agent
-func Get(agentID uuid.UUID) ([]merlinJob.Job, error) {
-	if core.Debug {
-		message("debug", "Entering into jobs.Get() function...")
+// AddRaw queues a job that was already built, instead of built from a job type and arguments, for the agent. The job
+// is given its own ID and token and any that it already had are replaced. Raw jobs can't be exported to a playbook
+// because they don't have a job type and arguments to be created from
+func AddRaw(agentID uuid.UUID, job merlinJob.Job) (string, error) {
+	mutex.RLock()
+	stopped := shuttingDown
+	mutex.RUnlock()
+	if stopped {
+		return "", fmt.Errorf("the server is shutting down and is not accepting new jobs")
 	}
-	var jobs []merlinJob.Job
-	_, ok := agents.Agents[agentID]
+	agent, ok := agents.Agents[agentID]
 	if !ok {
-		return jobs, fmt.Errorf("%s is not a valid agent", agentID)
+		return "", fmt.Errorf("%s is not a valid agent", agentID)
+	}
+	switch job.Type {
+	case merlinJob.CMD, merlinJob.CONTROL, merlinJob.SHELLCODE, merlinJob.NATIVE, merlinJob.FILETRANSFER, merlinJob.MODULE:
+	default:
+		return "", fmt.Errorf("invalid job type: %d, it must be a job type that is sent to an agent", job.Type)
+	}
+	if job.Payload == nil {
+		return "", fmt.Errorf("the %s job does not have a payload", merlinJob.String(job.Type))
 	}
 
+	job.AgentID = agentID
+	mutex.Lock()
+	job.ID = newJobID()
+	token, err := jobToken(agentID, job.ID, job.Type)
+	if err != nil {
+		mutex.Unlock()
+		return "", err
+	}
+	job.Token = token
+	Jobs[job.ID] = info{
+		AgentID:  agentID,
+		Token:    token,
+		Type:     merlinJob.String(job.Type),
+		Status:   merlinJob.CREATED,
+		Created:  time.Now().UTC(),
+		Command:  fmt.Sprintf("%+v", job.Payload),
+		TTL:      ttl,
+		JobType:  job.Type,
+		Payload:  job.Payload,
+		Priority: PRIORITYNORMAL,
+	}
+	mutex.Unlock()
+	err = queue(agentID, job)
+	if err != nil {
+		discard(job.ID)
+		return "", err
+	}
+	logEvent(job.ID, agentID, merlinJob.CREATED, fmt.Sprintf("%s %+v", merlinJob.String(job.Type), job.Payload))
+	agent.Log(fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Payload:%+v", merlinJob.String(job.Type), job.ID, "Created", job.Payload))
+	return job.ID, nil
+}
+
+// Resend creates a new job, with its own ID and token, from a previous job's payload and queues it for the same Agent.
+// Only a job that expired, timed out, or was canceled can be resent
+func Resend(jobID string) (string, error) {
+	mutex.RLock()
+	j, ok := Jobs[jobID]
+	stopped := shuttingDown
+	mutex.RUnlock()
+	if stopped {
+		return "", fmt.Errorf("the server is shutting down and is not accepting new jobs")
+	}
+	if !ok {
+		return "", fmt.Errorf("job %s does not exist", jobID)
+	}
+	switch j.Status {
+	case merlinJob.EXPIRED, merlinJob.TIMEOUT, merlinJob.CANCELED:
+	default:
+		return "", fmt.Errorf("job %s can not be resent because its status is %s, only expired, timed out, or canceled jobs can be resent", jobID, StatusString(j.Status))
+	}
+	if j.Payload == nil {
+		return "", fmt.Errorf("job %s does not have a payload that can be resent", jobID)
+	}
+
+	job := merlinJob.Job{
+		AgentID: j.AgentID,
+		Type:    j.JobType,
+		Payload: j.Payload,
+	}
+	mutex.Lock()
+	job.ID = newJobID()
+	token, err := jobToken(job.AgentID, job.ID, job.Type)
+	if err != nil {
+		mutex.Unlock()
+		return "", err
+	}
+	job.Token = token
+	Jobs[job.ID] = info{
+		AgentID:    job.AgentID,
+		Token:      job.Token,
+		Type:       j.Type,
+		Status:     merlinJob.CREATED,
+		Created:    time.Now().UTC(),
+		Command:    j.Command,
+		TTL:        ttl,
+		JobType:    job.Type,
+		Payload:    job.Payload,
+		OriginalID: jobID,
+		Name:       j.Name,
+		Args:       j.Args,
+		Priority:   j.Priority,
+		Timeout:    j.Timeout,
+	}
+	mutex.Unlock()
+	// A chunked upload is read from the same source file
+	transfersMutex.Lock()
+	u, ok := uploads[jobID]
+	transfersMutex.Unlock()
+	if ok {
+		setUpload(job.ID, u)
+		mutex.Lock()
+		n := Jobs[job.ID]
+		n.TotalChunks = j.TotalChunks
+		Jobs[job.ID] = n
+		mutex.Unlock()
+	}
+	err = queue(job.AgentID, job)
+	if err != nil {
+		discard(job.ID)
+		return "", err
+	}
+	logEvent(job.ID, job.AgentID, merlinJob.CREATED, fmt.Sprintf("Resent from job %s", jobID))
+
+	agent, ok := agents.Agents[job.AgentID]
+	if ok {
+		agent.Log(fmt.Sprintf("Resent job %s as job Type:%s, ID:%s, Status:%s", jobID, j.Type, job.ID, "Created"))
+	}
+	return job.ID, nil
+}
+
+// Reassign moves a job that has not been sent from its Agent's channel to a different Agent's channel as a new job
+// with its own ID and token, marks the original job canceled, and returns the new job's ID
+func Reassign(jobID string, newAgentID uuid.UUID) (string, error) {
+	mutex.RLock()
+	j, ok := Jobs[jobID]
+	stopped := shuttingDown
+	mutex.RUnlock()
+	if stopped {
+		return "", fmt.Errorf("the server is shutting down and is not accepting new jobs")
+	}
+	if !ok {
+		return "", fmt.Errorf("job %s does not exist", jobID)
+	}
+	if _, ok = agents.Agents[newAgentID]; !ok {
+		return "", fmt.Errorf("%s is not a valid agent", newAgentID)
+	}
+	if uuid.Equal(j.AgentID, newAgentID) {
+		return "", fmt.Errorf("job %s is already assigned to agent %s", jobID, newAgentID)
+	}
+	if j.Status != merlinJob.CREATED {
+		return "", fmt.Errorf("job %s for agent %s can not be reassigned because its status is %s", jobID, j.AgentID, StatusString(j.Status))
+	}
+	if j.DependsOn != "" {
+		return "", fmt.Errorf("job %s can not be reassigned because it depends on job %s for agent %s", jobID, j.DependsOn, j.AgentID)
+	}
+
+	removed := filterChannel(j.AgentID, func(job merlinJob.Job) bool {
+		return job.ID != jobID
+	})
+	if len(removed) == 0 {
+		return "", fmt.Errorf("job %s was not found in the queue for agent %s", jobID, j.AgentID)
+	}
+
+	job := merlinJob.Job{
+		AgentID: newAgentID,
+		Type:    j.JobType,
+		Payload: j.Payload,
+	}
+	mutex.Lock()
+	job.ID = newJobID()
+	token, err := jobToken(job.AgentID, job.ID, job.Type)
+	if err != nil {
+		mutex.Unlock()
+		_ = queue(j.AgentID, removed[0])
+		return "", err
+	}
+	job.Token = token
+	Jobs[job.ID] = info{
+		AgentID:     newAgentID,
+		Token:       job.Token,
+		Type:        j.Type,
+		Status:      merlinJob.CREATED,
+		Created:     time.Now().UTC(),
+		Command:     j.Command,
+		TTL:         j.TTL,
+		JobType:     job.Type,
+		Payload:     job.Payload,
+		TotalChunks: j.TotalChunks,
+		OriginalID:  jobID,
+		Name:        j.Name,
+		Args:        j.Args,
+		Priority:    j.Priority,
+		Timeout:     j.Timeout,
+	}
+	mutex.Unlock()
+	// A chunked upload is read from the same source file
+	transfersMutex.Lock()
+	u, ok := uploads[jobID]
+	transfersMutex.Unlock()
+	if ok {
+		setUpload(job.ID, u)
+	}
+	err = queue(newAgentID, job)
+	if err != nil {
+		discard(job.ID)
+		// Put the original job back so that it isn't lost
+		if errQueue := queue(j.AgentID, removed[0]); errQueue != nil {
+			message("warn", fmt.Sprintf("there was an error returning job %s to the queue for agent %s:\r\n%s", jobID, j.AgentID, errQueue))
+		}
+		return "", err
+	}
+
+	mutex.Lock()
+	j, ok = Jobs[jobID]
+	if ok {
+		j.Status = merlinJob.CANCELED
+		j.Completed = time.Now().UTC()
+		Jobs[jobID] = j
+	}
+	mutex.Unlock()
+	logEvent(jobID, j.AgentID, merlinJob.CANCELED, fmt.Sprintf("Reassigned to agent %s as job %s", newAgentID, job.ID))
+	logEvent(job.ID, newAgentID, merlinJob.CREATED, fmt.Sprintf("Reassigned from job %s for agent %s", jobID, j.AgentID))
+	cancelDependents(jobID)
+
+	if agent, ok := agents.Agents[j.AgentID]; ok {
+		agent.Log(fmt.Sprintf("Reassigned job %s to agent %s as job %s", jobID, newAgentID, job.ID))
+	}
+	if agent, ok := agents.Agents[newAgentID]; ok {
+		agent.Log(fmt.Sprintf("Reassigned job %s from agent %s as job Type:%s, ID:%s, Status:%s", jobID, j.AgentID, j.Type, job.ID, "Created"))
+	}
+	return job.ID, nil
+}
+
+// Clear removes any jobs the queue that have been created, but NOT sent to the agent
+func Clear(agentID uuid.UUID) error {
+	if core.Debug {
+		message("debug", "Entering into jobs.Clear() function...")
+	}
+	_, err := clearChannel(agentID)
+	return err
+}
+
+// clearChannel cancels every job in the Agent's job channel and returns the number of jobs canceled
+func clearChannel(agentID uuid.UUID) (int, error) {
+
+	//_, ok := agents.Agents[agentID]
+	//if !ok {
+	//	return fmt.Errorf("%s is not a valid agent", agentID)
+	//}
+
+	// The dependents of the canceled jobs are canceled after the Agent's lock is released so that dependentsMutex is
+	// never taken while the Agent's lock is held
+	var canceled []string
+	defer func() {
+		for _, id := range canceled {
+			cancelDependents(id)
+		}
+	}()
+
+	unlock := lockAgent(agentID)
+	defer unlock()
+
+	// Empty the job channel
+	mutex.RLock()
 	jobChannel, k := JobsChannel[agentID]
+	mutex.RUnlock()
 	if !k {
 		// There was not a jobs channel for this agent
-		return jobs, nil
+		return 0, nil
 	}
-
-	// Check to see if there are any jobs
+	var count int
 	jobLength := len(jobChannel)
 	if jobLength > 0 {
 		for i := 0; i < jobLength; i++ {
 			job := <-jobChannel
-			jobs = append(jobs, job)
-			// Update Job Info map
+			// Update Job Info structure
+			mutex.Lock()
 			j, ok := Jobs[job.ID]
 			if ok {
-				j.Status = merlinJob.SENT
-				j.Sent = time.Now().UTC()
+				j.Status = merlinJob.CANCELED
+				j.Completed = time.Now().UTC()
 				Jobs[job.ID] = j
-			} else {
-				return jobs, fmt.Errorf("invalid job %s for agent %s", job.ID, agentID)
 			}
+			mutex.Unlock()
+			if !ok {
+				return count, fmt.Errorf("invalid job %s for agent %s", job.ID, agentID)
+			}
+			count++
+			logEvent(job.ID, agentID, merlinJob.CANCELED, "Cleared from the queue")
+			canceled = append(canceled, job.ID)
 			if core.Debug {
 				message("debug", fmt.Sprintf("Channel command string: %+v", job))
 				message("debug", fmt.Sprintf("Job type: %s", merlinJob.String(job.Type)))
 			}
 		}
 	}
+	return count, nil
+}
+
+// Cancel cancels a single job that has not been sent, see CancelJob, or stops a sent job's file transfer that is being
+// written to disk
+func Cancel(agentID uuid.UUID, jobID string) error {
+	mutex.RLock()
+	j, ok := Jobs[jobID]
+	mutex.RUnlock()
+	if !ok || !uuid.Equal(j.AgentID, agentID) {
+		return fmt.Errorf("job %s was not found for agent %s", jobID, agentID)
+	}
+	// A file transfer that is being written to disk is stopped
+	if (j.Status == merlinJob.SENT || j.Status == merlinJob.RETURNED) && cancelOperation(jobID) {
+		mutex.Lock()
+		j, ok = Jobs[jobID]
+		if ok {
+			j.Status = merlinJob.CANCELED
+			j.Completed = time.Now().UTC()
+			Jobs[jobID] = j
+		}
+		mutex.Unlock()
+		logEvent(jobID, agentID, merlinJob.CANCELED, "Canceled the file transfer in progress")
+		cancelDependents(jobID)
+		return nil
+	}
+	return CancelJob(agentID, jobID)
+}
+
+// CancelJob removes a single job that has not been sent from the Agent's job channel, leaving the other jobs queued in
+// order, and marks it canceled. An error is returned if the job was not found or was already sent
+func CancelJob(agentID uuid.UUID, jobID string) error {
+	mutex.RLock()
+	j, ok := Jobs[jobID]
+	mutex.RUnlock()
+	if !ok || !uuid.Equal(j.AgentID, agentID) {
+		return fmt.Errorf("job %s was not found for agent %s", jobID, agentID)
+	}
+	if j.Status != merlinJob.CREATED {
+		return fmt.Errorf("job %s for agent %s can not be canceled because its status is %s", jobID, agentID, StatusString(j.Status))
+	}
+
+	removed := filterChannel(agentID, func(job merlinJob.Job) bool {
+		return job.ID != jobID
+	})
+	if len(removed) == 0 && !unhold(jobID) {
+		return fmt.Errorf("job %s was not found in the queue for agent %s", jobID, agentID)
+	}
+
+	mutex.Lock()
+	j, ok = Jobs[jobID]
+	if ok {
+		j.Status = merlinJob.CANCELED
+		j.Completed = time.Now().UTC()
+		Jobs[jobID] = j
+	}
+	mutex.Unlock()
+	logEvent(jobID, agentID, merlinJob.CANCELED, "Canceled")
+	cancelDependents(jobID)
+	return nil
+}
+
+// ClearCreated removes all unsent jobs across all agents
+func ClearCreated() error {
+	if core.Debug {
+		message("debug", "Entering into jobs.Clear() function...")
+	}
+	_, err := ClearAll()
+	return err
+}
+
+// ClearAll cancels every unsent job in every Agent's job channel and returns the number of jobs canceled
+func ClearAll() (int, error) {
+	// The channels are drained after the lock is released because canceling a job locks the Jobs map
+	mutex.RLock()
+	var agentIDs []uuid.UUID
+	for id := range JobsChannel {
+		agentIDs = append(agentIDs, id)
+	}
+	mutex.RUnlock()
+
+	var total int
+	for _, id := range agentIDs {
+		count, err := clearChannel(id)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Get returns a list of jobs that need to be sent to the agent
+func Get(agentID uuid.UUID) ([]merlinJob.Job, error) {
+	if core.Debug {
+		message("debug", "Entering into jobs.Get() function...")
+	}
+	var jobs []merlinJob.Job
+	_, ok := agents.Agents[agentID]
+	if !ok {
+		return jobs, fmt.Errorf("%s is not a valid agent", agentID)
+	}
+
+	// The dependents of jobs that failed are canceled after the Agent's lock is released
+	var failed []string
+	defer func() {
+		for _, id := range failed {
+			cancelDependents(id)
+		}
+	}()
+
+	unlock := lockAgent(agentID)
+	defer unlock()
+
+	mutex.RLock()
+	jobChannel, k := JobsChannel[agentID]
+	mutex.RUnlock()
+	if !k {
+		// There was not a jobs channel for this agent
+		return jobs, nil
+	}
+
+	// Check to see if there are any jobs
+	var pending, deferred []merlinJob.Job
+	budget := responseBudget(agentID)
+	var size int
+	// Take every queued job at once so that the jobs returned are a consistent snapshot of the queue
+	queued := make([]merlinJob.Job, 0, len(jobChannel))
+	for len(jobChannel) > 0 {
+		queued = append(queued, <-jobChannel)
+	}
+	requeue := func() {
+		for _, job := range deferred {
+			jobChannel <- job
+		}
+		for _, job := range pending {
+			enqueue(jobChannel, job)
+		}
+	}
+	for i, job := range queued {
+		// Once a job is over the response budget it and every job after it wait for the next check in, in order
+		if len(deferred) > 0 {
+			deferred = append(deferred, job)
+			continue
+		}
+		// Update Job Info map
+		mutex.Lock()
+		j, ok := Jobs[job.ID]
+		if ok && j.Status == merlinJob.EXPIRED {
+			// The job expired before ExpireJobs removed it from the channel
+			mutex.Unlock()
+			continue
+		}
+		mutex.Unlock()
+		if !ok {
+			// Return the jobs that weren't reached to the queue
+			deferred = append(deferred, queued[i+1:]...)
+			requeue()
+			return jobs, fmt.Errorf("invalid job %s for agent %s", job.ID, agentID)
+		}
+		// Read the next chunk of a chunked upload from disk and queue the chunk after it for the next check in
+		original := job
+		next, err := fillChunk(&job)
+		if err != nil {
+			// The chunk can't be read so the upload is canceled instead of being left in the created or sent status
+			message("warn", err.Error())
+			if agent, k := agents.Agents[agentID]; k {
+				agent.Log(err.Error())
+			}
+			mutex.Lock()
+			j, ok = Jobs[job.ID]
+			if ok {
+				j.Status = merlinJob.CANCELED
+				j.Completed = time.Now().UTC()
+				j.Result.Stderr = truncate(j.Result.Stderr+err.Error(), resultLimit)
+				Jobs[job.ID] = j
+			}
+			mutex.Unlock()
+			logEvent(job.ID, agentID, merlinJob.CANCELED, fmt.Sprintf("The upload could not be read: %s", err))
+			failed = append(failed, job.ID)
+			continue
+		}
+		// At least one job is always sent so that a job larger than the budget isn't stuck in the queue. A deferred
+		// chunk is re-queued without its data so that it is read again, along with the chunk after it, when sent
+		n := jobSize(job)
+		if budget > 0 && len(jobs) > 0 && size+n > budget {
+			deferred = append(deferred, original)
+			continue
+		}
+		size += n
+		if next != nil {
+			pending = append(pending, *next)
+		}
+		if ft, k := job.Payload.(merlinJob.FileTransfer); k && ft.IsDownload {
+			countBytes(blobSize(ft.FileBlob))
+		}
+		mutex.Lock()
+		j, ok = Jobs[job.ID]
+		var sent bool
+		if ok {
+			if j.Status == merlinJob.CREATED {
+				j.Status = merlinJob.SENT
+				j.Sent = time.Now().UTC()
+				sent = true
+				if j.Timeout > 0 {
+					j.Expires = j.Sent.Add(j.Timeout)
+				}
+			}
+			if ft, k := job.Payload.(merlinJob.FileTransfer); k && ft.TotalChunks > 1 && ft.IsDownload {
+				j.Chunk = ft.Chunk + 1
+			}
+			Jobs[job.ID] = j
+		}
+		mutex.Unlock()
+		if sent {
+			logEvent(job.ID, agentID, merlinJob.SENT, "")
+		}
+		jobs = append(jobs, job)
+		if core.Debug {
+			message("debug", fmt.Sprintf("Channel command string: %+v", job))
+			message("debug", fmt.Sprintf("Job type: %s", merlinJob.String(job.Type)))
+		}
+	}
+	requeue()
 	if core.Debug {
 		message("debug", fmt.Sprintf("Returning jobs:\r\n%+v", jobs))
 	}
@@ -580,14 +1567,17 @@ func Handler(m messages.Base) (messages.Base, error) {
 	if m.Type != messages.JOBS {
 		return returnMessage, fmt.Errorf("invalid message type: %s for job handler", messages.String(m.Type))
 	}
-	jobs := m.Payload.([]merlinJob.Job)
 	a, ok := agents.Agents[m.ID]
 	if !ok {
 		return returnMessage, fmt.Errorf("%s is not a valid agent", m.ID)
 	}
+	jobs, ok := m.Payload.([]merlinJob.Job)
+	if !ok {
+		return returnMessage, payloadError(a, fmt.Sprintf("the %s message", messages.String(m.Type)), m.Payload, "a list of jobs")
+	}
 
-	a.StatusCheckIn = time.Now().UTC()
-	returnMessage.Padding = core.RandStringBytesMaskImprSrc(a.PaddingMax)
+	a.CheckIn()
+	returnMessage.Padding = padding(a.PaddingMax)
 
 	var returnJobs []merlinJob.Job
 
@@ -602,21 +1592,113 @@ func Handler(m messages.Base) (messages.Base, error) {
 				if job.Type != merlinJob.RESULT {
 					return returnMessage, err
 				}
+				// Results for a known job are only accepted with the job's token from the agent it was sent to, and
+				// results for a job that already finished are a replay
+				mutex.RLock()
+				_, known := Jobs[job.ID]
+				mutex.RUnlock()
+				if known {
+					message("warn", err.Error())
+					agent.Log(fmt.Sprintf("Ignored results for job %s:\r\n%s", job.ID, err))
+					continue
+				}
 				if core.Debug {
-					message("debug", fmt.Sprintf("Received %s message without job token.\r\n%s", messages.String(job.Type), err))
+					message("debug", fmt.Sprintf("Received %s message without job token.\r\n%s", merlinJob.String(job.Type), err))
 				}
 			}
+			status := merlinJob.COMPLETE
 			switch job.Type {
 			case merlinJob.RESULT:
+				agent.LastResult = time.Now().UTC()
 				agent.Log(fmt.Sprintf("Results for job: %s", job.ID))
 
+				result, k := job.Payload.(merlinJob.Results)
+				if !k {
+					return returnMessage, payloadError(agent, fmt.Sprintf("the %s message for job %s", merlinJob.String(job.Type), job.ID), job.Payload, "results")
+				}
+				// Results that can arrive out of order are added to the job in sequence, those that arrive before the
+				// results in front of them wait until they can be added. Only Final says whether more sequenced
+				// results follow, and only Partial says whether more unsequenced results follow
+				if result.Sequence > 0 {
+					result.Partial = !result.Final
+					var ready bool
+					var err error
+					result, ready, err = sequenceResult(job.ID, result)
+					if err != nil {
+						message("warn", err.Error())
+						agent.Log(fmt.Sprintf("Ignored results for job %s:\r\n%s", job.ID, err))
+						continue
+					}
+					if !ready {
+						job.Payload = result
+						status = merlinJob.RETURNED
+						break
+					}
+				}
+				result.Final = false
+				job.Payload = result
+				// A long-running command returns its output as it is produced, the job completes with the last results
+				if result.Partial {
+					status = merlinJob.RETURNED
+				}
+				var code string
+				mutex.RLock()
+				if c := exitCode(Jobs[job.ID].JobType, result); c != "" && !result.Partial {
+					code = " with exit code " + c
+				}
+				mutex.RUnlock()
 				userMessage := messageAPI.UserMessage{
 					Level:   messageAPI.Note,
 					Time:    time.Now().UTC(),
-					Message: fmt.Sprintf("Results job %s for agent %s at %s", job.ID, job.AgentID, time.Now().UTC().Format(time.RFC3339)),
+					Message: fmt.Sprintf("Results job %s for agent %s at %s%s", job.ID, job.AgentID, time.Now().UTC().Format(time.RFC3339), code),
 				}
 				messageAPI.SendBroadcastMessage(userMessage)
-				result := job.Payload.(merlinJob.Results)
+				// Render a process listing as a table so that it is displayed and stored like any other output
+				if len(result.Processes) > 0 && result.Stdout == "" {
+					result.Stdout = processTable(result.Processes)
+					job.Payload = result
+				}
+				if len(result.Connections) > 0 && result.Stdout == "" {
+					result.Stdout = connectionTable(result.Connections)
+					job.Payload = result
+				}
+				if len(result.Registry) > 0 && result.Stdout == "" {
+					result.Stdout = registryTable(result.Registry)
+					job.Payload = result
+				}
+				if isWhoami(job.ID) {
+					// Refresh the agent's cached security context
+					if result.Identity != "" {
+						agent.Log(fmt.Sprintf("Agent identity is %s according to job %s, previously %s", result.Identity, job.ID, agent.UserName))
+						agent.UpdateInfo(whoamiInfo(agent, result))
+					}
+					if result.Stdout == "" {
+						result.Stdout = identityTable(result)
+						job.Payload = result
+					}
+				} else if result.Identity != "" {
+					// The agent now runs as the impersonated user
+					agent.Log(fmt.Sprintf("Agent identity changed from %s to %s by job %s", agent.UserName, result.Identity, job.ID))
+					agent.UserName = result.Identity
+					if result.Stdout == "" {
+						result.Stdout = fmt.Sprintf("Successfully impersonated %s", result.Identity)
+						job.Payload = result
+					}
+				}
+				// Sensitive output is redacted before it is broadcast, logged, or stored
+				storeUnredacted(job.ID, result)
+				result = redact(result)
+				job.Payload = result
+				errJSON := writeJSON(job, result)
+				if errJSON != nil {
+					message("warn", errJSON.Error())
+				}
+				mutex.RLock()
+				sessionID := Jobs[job.ID].Session
+				mutex.RUnlock()
+				if sessionID != "" {
+					sessionResult(sessionID, result)
+				}
 				if len(result.Stdout) > 0 {
 					agent.Log(fmt.Sprintf("Command Results (stdout):\r\n%s", result.Stdout))
 					userMessage := messageAPI.UserMessage{
@@ -636,20 +1718,68 @@ func Handler(m messages.Base) (messages.Base, error) {
 					messageAPI.SendBroadcastMessage(userMessage)
 				}
 			case merlinJob.AGENTINFO:
-				agent.UpdateInfo(job.Payload.(messages.AgentInfo))
+				info, k := job.Payload.(messages.AgentInfo)
+				if !k {
+					return returnMessage, payloadError(agent, fmt.Sprintf("the %s message for job %s", merlinJob.String(job.Type), job.ID), job.Payload, "agent information")
+				}
+				agent.UpdateInfo(info)
+				agentInfo(job.AgentID, info)
 			case merlinJob.FILETRANSFER:
-				err := fileTransfer(job.AgentID, job.Payload.(merlinJob.FileTransfer))
+				p, k := job.Payload.(merlinJob.FileTransfer)
+				if !k {
+					return returnMessage, payloadError(agent, fmt.Sprintf("the %s message for job %s", merlinJob.String(job.Type), job.ID), job.Payload, "a file transfer")
+				}
+				// The file operation is canceled when the job is canceled or the server shuts down
+				ctx, finished := fileOperation(job.ID)
+				done, err := fileTransfer(ctx, job.AgentID, job.ID, p)
+				finished()
 				if err != nil {
 					return returnMessage, err
 				}
+				// A directory download isn't complete until every file in the directory has been received
+				if done {
+					done = directoryReceived(job.ID, p)
+				}
+				// The job isn't complete until the last chunk of a file has been received
+				if !done {
+					status = merlinJob.RETURNED
+				}
 			}
 			// Update Jobs Info structure
+			mutex.Lock()
 			j, k := Jobs[job.ID]
+			var completed bool
 			if k {
-				j.Status = merlinJob.COMPLETE
-				j.Completed = time.Now().UTC()
+				// A chunked upload isn't complete until the agent returns the last chunk
+				if status == merlinJob.COMPLETE && j.TotalChunks > 0 && j.Chunk < j.TotalChunks {
+					status = merlinJob.RETURNED
+				}
+				completed = status == merlinJob.COMPLETE && j.Status != merlinJob.COMPLETE
+				j.Status = status
+				if status == merlinJob.COMPLETE {
+					j.Completed = time.Now().UTC()
+				}
+				if result, r := job.Payload.(merlinJob.Results); r {
+					// Count each set of results a job returns, a chunked file transfer already counts its chunks
+					if j.TotalChunks == 0 {
+						j.Chunk++
+					}
+					j.Result.Stdout = truncate(j.Result.Stdout+result.Stdout, resultLimit)
+					j.Result.Stderr = truncate(j.Result.Stderr+result.Stderr, resultLimit)
+					if !result.Partial {
+						j.Result.ExitCode = result.ExitCode
+						j.Result.HasExitCode = result.HasExitCode
+					}
+				}
 				Jobs[job.ID] = j
 			}
+			mutex.Unlock()
+			if completed {
+				logEvent(job.ID, job.AgentID, merlinJob.COMPLETE, "")
+				deleteUpload(job.ID)
+				jobComplete(job.ID, j)
+				releaseDependents(job.ID)
+			}
 		} else {
 			userMessage := messageAPI.UserMessage{
 				Level:   messageAPI.Warn,
@@ -695,8 +1825,8 @@ func Idle(agentID uuid.UUID) (messages.Base, error) {
 		message("success", fmt.Sprintf("Received agent status checkin from %s", agentID))
 	}
 
-	agent.StatusCheckIn = time.Now().UTC()
-	returnMessage.Padding = core.RandStringBytesMaskImprSrc(agent.PaddingMax)
+	agent.CheckIn()
+	returnMessage.Padding = padding(agent.PaddingMax)
 	// See if there are any new jobs to send back
 	jobs, err := Get(agentID)
 	if err != nil {
@@ -716,63 +1846,217 @@ func GetTableActive(agentID uuid.UUID) ([][]string, error) {
 	if core.Debug {
 		message("debug", fmt.Sprintf("entering into jobs.GetTableActive for agent %s", agentID.String()))
 	}
+	return GetTable(agentID, merlinJob.CREATED, merlinJob.SENT, merlinJob.RETURNED)
+}
+
+// GetTable returns a list of rows that contain information about the agent's jobs in any of the provided statuses,
+// sorted by creation time. All jobs are returned when no statuses are provided
+func GetTable(agentID uuid.UUID, statuses ...int) ([][]string, error) {
 	var jobs [][]string
 	_, ok := agents.Agents[agentID]
 	if !ok {
 		return jobs, fmt.Errorf("%s is not a valid agent", agentID)
 	}
 
+	var active []entry
+	mutex.RLock()
 	for id, job := range Jobs {
-		if job.AgentID == agentID {
-			//message("debug", fmt.Sprintf("GetTableActive(%s) ID: %s, Job: %+v", agentID.String(), id, job))
-			var status string
-			switch job.Status {
-			case merlinJob.CREATED:
-				status = "Created"
-			case merlinJob.SENT:
-				status = "Sent"
-			case merlinJob.RETURNED:
-				status = "Returned"
-			default:
-				status = fmt.Sprintf("Unknown job status: %d", job.Status)
+		if job.AgentID == agentID && hasStatus(job.Status, statuses) {
+			active = append(active, entry{id, job})
+		}
+	}
+	mutex.RUnlock()
+
+	// Sort by creation time, and then by ID, so that the table is the same between calls
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].job.Created.Equal(active[j].job.Created) {
+			return active[i].id < active[j].id
+		}
+		return active[i].job.Created.Before(active[j].job.Created)
+	})
+
+	var zeroTime time.Time
+	for _, e := range active {
+		var sent string
+		if e.job.Sent != zeroTime {
+			sent = e.job.Sent.Format(time.RFC3339)
+		}
+		var progress []string
+		if e.job.Status == merlinJob.SENT || e.job.Status == merlinJob.RETURNED {
+			if e.job.TotalFiles > 0 {
+				progress = append(progress, fmt.Sprintf("%d/%d files", e.job.Files, e.job.TotalFiles))
 			}
-			var zeroTime time.Time
-			// Don't add completed or canceled jobs
-			if job.Status != merlinJob.COMPLETE && job.Status != merlinJob.CANCELED {
-				var sent string
-				if job.Sent != zeroTime {
-					sent = job.Sent.Format(time.RFC3339)
-				}
-				// <JobID>, <Command>, <JobStatus>, <Created>, <Sent>
-				jobs = append(jobs, []string{
-					id,
-					job.Command,
-					status,
-					job.Created.Format(time.RFC3339),
-					sent,
-				})
+			if e.job.TotalChunks > 0 {
+				progress = append(progress, fmt.Sprintf("%d/%d chunks", e.job.Chunk, e.job.TotalChunks))
+			} else if e.job.Status == merlinJob.RETURNED && e.job.Chunk > 0 {
+				progress = append(progress, fmt.Sprintf("%d results", e.job.Chunk))
 			}
 		}
+		// <JobID>, <Command>, <JobStatus>, <Created>, <Sent>, <Progress>
+		jobs = append(jobs, []string{
+			e.id,
+			e.job.Command,
+			StatusString(e.job.Status),
+			e.job.Created.Format(time.RFC3339),
+			sent,
+			strings.Join(progress, ", "),
+		})
 	}
 	return jobs, nil
 }
 
-// GetTableAll returns all unsent jobs to be displayed as a table
-func GetTableAll() [][]string {
+// GetTableCompleted returns a list of rows that contain information about completed, canceled, or expired jobs for an agent
+// sorted by the completion time with the most recent job first
+func GetTableCompleted(agentID uuid.UUID) ([][]string, error) {
+	if core.Debug {
+		message("debug", fmt.Sprintf("entering into jobs.GetTableCompleted for agent %s", agentID.String()))
+	}
 	var jobs [][]string
+	_, ok := agents.Agents[agentID]
+	if !ok {
+		return jobs, fmt.Errorf("%s is not a valid agent", agentID)
+	}
+
+	var completed []entry
+	mutex.RLock()
 	for id, job := range Jobs {
-		var status string
-		switch job.Status {
-		case merlinJob.CREATED:
-			status = "Created"
-		case merlinJob.SENT:
-			status = "Sent"
-		case merlinJob.RETURNED:
-			status = "Returned"
-		default:
-			status = fmt.Sprintf("Unknown job status: %d", job.Status)
+		if job.AgentID == agentID && (job.Status == merlinJob.COMPLETE || job.Status == merlinJob.CANCELED || job.Status == merlinJob.EXPIRED || job.Status == merlinJob.TIMEOUT) {
+			completed = append(completed, entry{id, job})
 		}
-		if job.Status != merlinJob.COMPLETE && job.Status != merlinJob.CANCELED {
+	}
+	mutex.RUnlock()
+
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].job.Completed.After(completed[j].job.Completed)
+	})
+
+	var zeroTime time.Time
+	for _, e := range completed {
+		var sent, done string
+		if e.job.Sent != zeroTime {
+			sent = e.job.Sent.Format(time.RFC3339)
+		}
+		if e.job.Completed != zeroTime {
+			done = e.job.Completed.Format(time.RFC3339)
+		}
+		var code string
+		if e.job.Status == merlinJob.COMPLETE {
+			code = exitCode(e.job.JobType, e.job.Result)
+		}
+		// <JobID>, <Type>, <JobStatus>, <Created>, <Sent>, <Completed>, <Exit Code>
+		jobs = append(jobs, []string{
+			e.id,
+			e.job.Type,
+			StatusString(e.job.Status),
+			e.job.Created.Format(time.RFC3339),
+			sent,
+			done,
+			code,
+		})
+	}
+	return jobs, nil
+}
+
+// History returns every job for the agent, regardless of its status, oldest first
+func History(agentID uuid.UUID) ([]info, error) {
+	entries, err := history(agentID)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]info, 0, len(entries))
+	for _, e := range entries {
+		jobs = append(jobs, e.job)
+	}
+	return jobs, nil
+}
+
+// GetTableHistory returns a list of rows that contain the lifecycle of every job for an agent, oldest first
+func GetTableHistory(agentID uuid.UUID) ([][]string, error) {
+	entries, err := history(agentID)
+	if err != nil {
+		return nil, err
+	}
+	var jobs [][]string
+	var zeroTime time.Time
+	for _, e := range entries {
+		var sent, done string
+		if e.job.Sent != zeroTime {
+			sent = e.job.Sent.Format(time.RFC3339)
+		}
+		if e.job.Completed != zeroTime {
+			done = e.job.Completed.Format(time.RFC3339)
+		}
+		var code string
+		if e.job.Status == merlinJob.COMPLETE {
+			code = exitCode(e.job.JobType, e.job.Result)
+		}
+		// <JobID>, <Command>, <JobStatus>, <Created>, <Sent>, <Completed>, <Exit Code>
+		jobs = append(jobs, []string{
+			e.id,
+			e.job.Command,
+			StatusString(e.job.Status),
+			e.job.Created.Format(time.RFC3339),
+			sent,
+			done,
+			code,
+		})
+	}
+	return jobs, nil
+}
+
+// history returns every job for the agent sorted by the time it was created
+func history(agentID uuid.UUID) ([]entry, error) {
+	if _, ok := agents.Agents[agentID]; !ok {
+		return nil, fmt.Errorf("%s is not a valid agent", agentID)
+	}
+	var entries []entry
+	mutex.RLock()
+	for id, job := range Jobs {
+		if job.AgentID == agentID {
+			entries = append(entries, entry{id, job})
+		}
+	}
+	mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].job.Created.Equal(entries[j].job.Created) {
+			return entries[i].id < entries[j].id
+		}
+		return entries[i].job.Created.Before(entries[j].job.Created)
+	})
+	return entries, nil
+}
+
+// GetTableAll returns a list of rows that contain information about every job for the agent, including completed,
+// canceled, expired, and timed out jobs, sorted by creation time. The rows are those of GetTable with the time the job
+// completed appended
+func GetTableAll(agentID uuid.UUID) ([][]string, error) {
+	rows, err := GetTable(agentID)
+	if err != nil {
+		return rows, err
+	}
+	var zeroTime time.Time
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for i, row := range rows {
+		var completed string
+		if j, ok := Jobs[row[0]]; ok && j.Completed != zeroTime {
+			completed = j.Completed.Format(time.RFC3339)
+		}
+		// <JobID>, <Command>, <JobStatus>, <Created>, <Sent>, <Progress>, <Completed>
+		rows[i] = append(row, completed)
+	}
+	return rows, nil
+}
+
+// GetTableAllAgents returns all unsent jobs, across all agents, to be displayed as a table
+func GetTableAllAgents() [][]string {
+	var jobs [][]string
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for id, job := range Jobs {
+		status := StatusString(job.Status)
+		if job.Status != merlinJob.COMPLETE && job.Status != merlinJob.CANCELED && job.Status != merlinJob.EXPIRED && job.Status != merlinJob.TIMEOUT {
 			var zeroTime time.Time
 			var sent string
 			if job.Sent != zeroTime {
@@ -792,6 +2076,375 @@ func GetTableAll() [][]string {
 	return jobs
 }
 
+// SetTTL sets the default amount of time a new job can go without completing before it expires. Zero disables expiration
+func SetTTL(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("the job time to live can not be negative: %s", d)
+	}
+	mutex.Lock()
+	ttl = d
+	mutex.Unlock()
+	return nil
+}
+
+// SetQueueSize sets the maximum number of jobs that can wait in each Agent's channel before Add returns an error
+func SetQueueSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("the job queue size must be greater than 0: %d", size)
+	}
+	mutex.Lock()
+	queueSize = size
+	var agentIDs []uuid.UUID
+	for agentID := range JobsChannel {
+		agentIDs = append(agentIDs, agentID)
+	}
+	mutex.Unlock()
+
+	// Move the queued jobs of each existing Agent into a larger channel when the new size exceeds its capacity
+	for _, agentID := range agentIDs {
+		unlock := lockAgent(agentID)
+		mutex.Lock()
+		jobChannel := JobsChannel[agentID]
+		if cap(jobChannel) < size {
+			grown := make(chan merlinJob.Job, size)
+			jobLength := len(jobChannel)
+			for i := 0; i < jobLength; i++ {
+				grown <- <-jobChannel
+			}
+			JobsChannel[agentID] = grown
+		}
+		mutex.Unlock()
+		unlock()
+	}
+	return nil
+}
+
+// SetRetention sets how long completed, canceled, and expired jobs are kept before they are pruned. Zero keeps them forever
+func SetRetention(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("the job retention can not be negative: %s", d)
+	}
+	mutex.Lock()
+	retention = d
+	mutex.Unlock()
+	return nil
+}
+
+// Retention returns how long completed, canceled, and expired jobs are kept before they are pruned
+func Retention() time.Duration {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return retention
+}
+
+// Prune deletes completed, canceled, expired, and timed out jobs, along with their results, that finished more than olderThan ago
+// and returns the number of jobs that were deleted
+func Prune(olderThan time.Duration) int {
+	cutoff := time.Now().UTC().Add(-olderThan)
+	var pruned []string
+	mutex.Lock()
+	for id, j := range Jobs {
+		switch j.Status {
+		case merlinJob.COMPLETE, merlinJob.CANCELED, merlinJob.EXPIRED, merlinJob.TIMEOUT:
+			if j.Completed.Before(cutoff) {
+				delete(Jobs, id)
+				pruned = append(pruned, id)
+			}
+		}
+	}
+	mutex.Unlock()
+
+	transfersMutex.Lock()
+	for _, id := range pruned {
+		delete(uploads, id)
+	}
+	transfersMutex.Unlock()
+	sequencesMutex.Lock()
+	for _, id := range pruned {
+		delete(sequences, id)
+	}
+	sequencesMutex.Unlock()
+	return len(pruned)
+}
+
+// PurgeAgent deletes the Agent's job channel, lock, shell sessions, idempotency keys, and every job for the Agent,
+// along with their uploads and held dependent jobs, and returns the number of jobs that were deleted
+func PurgeAgent(agentID uuid.UUID) int {
+	unlock := lockAgent(agentID)
+	purged := make(map[string]bool)
+	mutex.Lock()
+	delete(JobsChannel, agentID)
+	delete(agentMutex, agentID)
+	for id, j := range Jobs {
+		if uuid.Equal(j.AgentID, agentID) {
+			delete(Jobs, id)
+			purged[id] = true
+		}
+	}
+	mutex.Unlock()
+	unlock()
+
+	transfersMutex.Lock()
+	for id := range purged {
+		delete(uploads, id)
+	}
+	transfersMutex.Unlock()
+	sequencesMutex.Lock()
+	for id := range purged {
+		delete(sequences, id)
+	}
+	sequencesMutex.Unlock()
+
+	dependentsMutex.Lock()
+	for id := range purged {
+		delete(dependents, id)
+	}
+	dependentsMutex.Unlock()
+
+	sessionsMutex.Lock()
+	for id, s := range sessions {
+		if uuid.Equal(s.AgentID, agentID) {
+			delete(sessions, id)
+		}
+	}
+	sessionsMutex.Unlock()
+	// The idempotency keys are deleted after the Agent's lock is released because AddIdempotent holds
+	// idempotencyMutex while it creates a job
+	idempotencyMutex.Lock()
+	for k := range idempotencyKeys {
+		if uuid.Equal(k.AgentID, agentID) {
+			delete(idempotencyKeys, k)
+		}
+	}
+	idempotencyMutex.Unlock()
+	return len(purged)
+}
+
+// ExpireJobs marks any created or sent job that is older than its time to live as expired, removes expired jobs that
+// haven't been sent from their Agent's channel, and returns the number of jobs that expired
+func ExpireJobs() int {
+	now := time.Now().UTC()
+	var expired []entry
+	mutex.Lock()
+	for id, j := range Jobs {
+		if j.TTL <= 0 || (j.Status != merlinJob.CREATED && j.Status != merlinJob.SENT) {
+			continue
+		}
+		if now.Sub(j.Created) > j.TTL {
+			expired = append(expired, entry{id, j})
+			j.Status = merlinJob.EXPIRED
+			j.Completed = now
+			Jobs[id] = j
+		}
+	}
+	mutex.Unlock()
+
+	drain := make(map[uuid.UUID]bool)
+	for _, e := range expired {
+		if e.job.Status == merlinJob.CREATED && !unhold(e.id) {
+			drain[e.job.AgentID] = true
+		}
+		logEvent(e.id, e.job.AgentID, merlinJob.EXPIRED, fmt.Sprintf("Expired after %s with a status of %s", e.job.TTL, StatusString(e.job.Status)))
+		cancelDependents(e.id)
+		if agent, ok := agents.Agents[e.job.AgentID]; ok {
+			agent.Log(fmt.Sprintf("Job %s expired after %s with a status of %s", e.id, e.job.TTL, StatusString(e.job.Status)))
+		}
+	}
+	for agentID := range drain {
+		filterChannel(agentID, func(job merlinJob.Job) bool {
+			mutex.RLock()
+			defer mutex.RUnlock()
+			return Jobs[job.ID].Status != merlinJob.EXPIRED
+		})
+	}
+	return len(expired)
+}
+
+// Expire marks any sent job that the agent didn't answer before the job's timeout with the TIMEOUT status, broadcasts
+// a message for each one, and returns the number of jobs that timed out. It is safe to call on a ticker
+func Expire() int {
+	now := time.Now().UTC()
+	var timedOut []entry
+	mutex.Lock()
+	for id, j := range Jobs {
+		if j.Status != merlinJob.SENT || j.Expires.IsZero() || now.Before(j.Expires) {
+			continue
+		}
+		j.Status = merlinJob.TIMEOUT
+		j.Completed = now
+		Jobs[id] = j
+		timedOut = append(timedOut, entry{id, j})
+	}
+	mutex.Unlock()
+
+	for _, e := range timedOut {
+		logEvent(e.id, e.job.AgentID, merlinJob.TIMEOUT, fmt.Sprintf("Not answered within %s of being sent", e.job.Timeout))
+		cancelDependents(e.id)
+		if agent, ok := agents.Agents[e.job.AgentID]; ok {
+			agent.Log(fmt.Sprintf("Job %s timed out after it was not answered within %s of being sent", e.id, e.job.Timeout))
+		}
+		messageAPI.SendBroadcastMessage(messageAPI.UserMessage{
+			Level:   messageAPI.Warn,
+			Time:    now,
+			Message: fmt.Sprintf("Job %s for agent %s timed out after it was not answered within %s of being sent", e.id, e.job.AgentID, e.job.Timeout),
+		})
+	}
+	return len(timedOut)
+}
+
+// Status returns the information about a single job by its ID
+func Status(jobID string) (info, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	j, ok := Jobs[jobID]
+	if !ok {
+		return j, fmt.Errorf("job %s does not exist", jobID)
+	}
+	return j, nil
+}
+
+// QueueDepth returns the number of jobs waiting in the Agent's channel that have not been sent. An agent without a
+// channel has a depth of 0 and an agent that doesn't exist returns an error
+func QueueDepth(agentID uuid.UUID) (int, error) {
+	if _, ok := agents.Agents[agentID]; !ok {
+		return 0, fmt.Errorf("%s is not a valid agent", agentID)
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	jobChannel, ok := JobsChannel[agentID]
+	if !ok {
+		return 0, nil
+	}
+	return len(jobChannel), nil
+}
+
+// AgentsWithJobs returns, sorted, the ID of every agent with a created, sent, or returned job or a job in its channel
+func AgentsWithJobs() []uuid.UUID {
+	mutex.RLock()
+	busy := make(map[uuid.UUID]bool)
+	for _, j := range Jobs {
+		if hasStatus(j.Status, []int{merlinJob.CREATED, merlinJob.SENT, merlinJob.RETURNED}) {
+			busy[j.AgentID] = true
+		}
+	}
+	for agentID, jobChannel := range JobsChannel {
+		if len(jobChannel) > 0 {
+			busy[agentID] = true
+		}
+	}
+	mutex.RUnlock()
+
+	var agentIDs []uuid.UUID
+	for agentID := range busy {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Slice(agentIDs, func(i, j int) bool {
+		return agentIDs[i].String() < agentIDs[j].String()
+	})
+	return agentIDs
+}
+
+// Count returns the number of jobs, across all agents, in each status. Returned jobs, which have more results to come,
+// are counted as sent, and expired and timed out jobs, which didn't complete, are counted as canceled so that the
+// counts add up to the number of jobs
+func Count() (created, sent, complete, canceled int) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, j := range Jobs {
+		switch j.Status {
+		case merlinJob.CREATED:
+			created++
+		case merlinJob.SENT, merlinJob.RETURNED:
+			sent++
+		case merlinJob.COMPLETE:
+			complete++
+		case merlinJob.CANCELED, merlinJob.EXPIRED, merlinJob.TIMEOUT:
+			canceled++
+		}
+	}
+	return
+}
+
+// GetResults returns the stored stdout and stderr for the job
+func GetResults(jobID string) (merlinJob.Results, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	j, ok := Jobs[jobID]
+	if !ok {
+		return merlinJob.Results{}, fmt.Errorf("job %s does not exist", jobID)
+	}
+	return j.Result, nil
+}
+
+// SetResultLimit sets the maximum number of bytes of stdout, and of stderr, stored for each job. Zero disables the limit
+func SetResultLimit(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("the job result limit can not be negative: %d", limit)
+	}
+	mutex.Lock()
+	resultLimit = limit
+	mutex.Unlock()
+	return nil
+}
+
+// StatusString returns the text representation of a job status constant
+func StatusString(status int) string {
+	switch status {
+	case merlinJob.CREATED:
+		return "Created"
+	case merlinJob.SENT:
+		return "Sent"
+	case merlinJob.RETURNED:
+		return "Returned"
+	case merlinJob.COMPLETE:
+		return "Complete"
+	case merlinJob.CANCELED:
+		return "Canceled"
+	case merlinJob.EXPIRED:
+		return "Expired"
+	case merlinJob.TIMEOUT:
+		return "Timeout"
+	default:
+		return fmt.Sprintf("Unknown job status: %d", status)
+	}
+}
+
+// ParseStatus returns the job status constant for its text representation (e.g., sent)
+func ParseStatus(status string) (int, error) {
+	for i := merlinJob.CREATED; i <= merlinJob.TIMEOUT; i++ {
+		if strings.EqualFold(status, StatusString(i)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown job status: %s", status)
+}
+
+// hasStatus returns true if the status is in the list of statuses or the list is empty
+func hasStatus(status int, statuses []int) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, s := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// payloadError logs and broadcasts a warning that the payload of an agent's message is not the type its message type
+// declares and returns it as an error
+func payloadError(agent *agents.Agent, what string, payload interface{}, expected string) error {
+	err := fmt.Errorf("%s from agent %s contained a %T payload instead of %s", what, agent.ID, payload, expected)
+	agent.Log(err.Error())
+	messageAPI.SendBroadcastMessage(messageAPI.UserMessage{
+		Level:   messageAPI.Warn,
+		Time:    time.Now().UTC(),
+		Message: err.Error(),
+	})
+	return err
+}
+
 // checkJob verifies that the input job message contains the expected token and was not already completed
 func checkJob(job merlinJob.Job) error {
 	// Check to make sure agent UUID is in dataset
@@ -799,24 +2452,36 @@ func checkJob(job merlinJob.Job) error {
 	if !ok {
 		return fmt.Errorf("job %s was for an invalid agent %s", job.ID, job.AgentID)
 	}
+	mutex.RLock()
 	j, k := Jobs[job.ID]
+	mutex.RUnlock()
 	if !k {
 		return fmt.Errorf("job %s was not found for agent %s", job.ID, job.AgentID)
 	}
-	if job.Token != j.Token {
-		return fmt.Errorf("job %s for agent %s did not contain the correct token.\r\nExpected: %s, Got: %s", job.ID, job.AgentID, j.Token, job.Token)
+	if err := checkToken(j.AgentID, job.ID, j.JobType, job.Token); err != nil {
+		return err
+	}
+	if !uuid.Equal(job.AgentID, j.AgentID) {
+		return fmt.Errorf("job %s belongs to agent %s, not agent %s", job.ID, j.AgentID, job.AgentID)
 	}
 	if j.Status == merlinJob.COMPLETE {
 		return fmt.Errorf("job %s for agent %s was previously completed on %s", job.ID, job.AgentID, j.Completed.UTC().Format(time.RFC3339))
 	}
 	if j.Status == merlinJob.CANCELED {
-		return fmt.Errorf("job %s for agent %s was previously canceled on", job.ID, job.AgentID)
+		return fmt.Errorf("job %s for agent %s was previously canceled on %s", job.ID, job.AgentID, j.Completed.UTC().Format(time.RFC3339))
+	}
+	if j.Status == merlinJob.EXPIRED {
+		return fmt.Errorf("job %s for agent %s expired on %s", job.ID, job.AgentID, j.Completed.UTC().Format(time.RFC3339))
+	}
+	if j.Status == merlinJob.TIMEOUT {
+		return fmt.Errorf("job %s for agent %s timed out on %s", job.ID, job.AgentID, j.Completed.UTC().Format(time.RFC3339))
 	}
 	return nil
 }
 
-// fileTransfer handles file upload/download operations
-func fileTransfer(agentID uuid.UUID, p merlinJob.FileTransfer) error {
+// fileTransfer handles file upload/download operations and returns true when the transfer is complete. Writing the file
+// stops, and the partial file is removed, when the context is done
+func fileTransfer(ctx context.Context, agentID uuid.UUID, jobID string, p merlinJob.FileTransfer) (bool, error) {
 	if core.Debug {
 		message("debug", "Entering into agents.FileTransfer")
 	}
@@ -824,16 +2489,15 @@ func fileTransfer(agentID uuid.UUID, p merlinJob.FileTransfer) error {
 	// Check to make sure it is a known agent
 	agent, ok := agents.Agents[agentID]
 	if !ok {
-		return fmt.Errorf("%s is not a valid agent", agentID)
+		return false, fmt.Errorf("%s is not a valid agent", agentID)
 	}
 
 	if p.IsDownload {
-		agentsDir := filepath.Join(core.CurrentDir, "data", "agents")
-		_, f := filepath.Split(p.FileLocation) // We don't need the directory part for anything
-		if _, errD := os.Stat(agentsDir); os.IsNotExist(errD) {
-			errorMessage := fmt.Errorf("there was an error locating the agent's directory:\r\n%s", errD.Error())
+		agentDir := filepath.Join(getDownloadDir(), agentID.String())
+		if errD := os.MkdirAll(agentDir, 0750); errD != nil {
+			errorMessage := fmt.Errorf("there was an error creating the agent's download directory %s:\r\n%s", agentDir, errD)
 			agent.Log(errorMessage.Error())
-			return errorMessage
+			return false, errorMessage
 		}
 		message("success", fmt.Sprintf("Results for %s at %s", agentID, time.Now().UTC().Format(time.RFC3339)))
 		downloadBlob, downloadBlobErr := base64.StdEncoding.DecodeString(p.FileBlob)
@@ -841,14 +2505,59 @@ func fileTransfer(agentID uuid.UUID, p merlinJob.FileTransfer) error {
 		if downloadBlobErr != nil {
 			errorMessage := fmt.Errorf("there was an error decoding the fileBlob:\r\n%s", downloadBlobErr.Error())
 			agent.Log(errorMessage.Error())
-			return errorMessage
+			return false, errorMessage
+		}
+		countBytes(len(downloadBlob))
+		// The integrity hash and file type checks are of the original, decompressed, file
+		if p.Compressed {
+			downloadBlob, downloadBlobErr = decompress(downloadBlob)
+			if downloadBlobErr != nil {
+				agent.Log(downloadBlobErr.Error())
+				return false, downloadBlobErr
+			}
 		}
-		downloadFile := filepath.Join(agentsDir, agentID.String(), f)
-		writingErr := ioutil.WriteFile(downloadFile, downloadBlob, 0600)
+		var downloadFile string
+		var err error
+		root, isDir := directoryRoot(jobID)
+		if isScreenshot(jobID) {
+			downloadFile, err = screenshotPath(agentDir, jobID, p, downloadBlob)
+		} else if isDir {
+			downloadFile, err = directoryPath(agentDir, jobID, root, p.FileLocation)
+		} else {
+			downloadFile, err = downloadPath(agentDir, jobID, p.FileLocation)
+		}
+		if err != nil {
+			agent.Log(err.Error())
+			return false, err
+		}
+		// An empty directory is returned as a single file transfer for the directory itself without any files
+		if isDir && p.Files == 0 {
+			if errD := os.MkdirAll(downloadFile, 0750); errD != nil {
+				errorMessage := fmt.Errorf("there was an error creating the directory %s:\r\n%s", downloadFile, errD)
+				agent.Log(errorMessage.Error())
+				return false, errorMessage
+			}
+			successMessage := fmt.Sprintf("Successfully downloaded empty directory %s from agent %s to %s", root, agentID, downloadFile)
+			message("success", successMessage)
+			agent.Log(successMessage)
+			return true, nil
+		}
+		if p.TotalChunks > 1 {
+			return writeChunk(ctx, agent, jobID, downloadFile, p, downloadBlob)
+		}
+		// Verify the file's integrity before writing it to disk
+		if p.Hash != "" {
+			fileHash := sha256.Sum256(downloadBlob)
+			errHash := checkHash(p.FileLocation, p.Hash, fileHash[:])
+			if errHash != nil {
+				agent.Log(errHash.Error())
+				return false, errHash
+			}
+		}
+		writingErr := writeDownload(ctx, agent, downloadFile, downloadBlob)
 		if writingErr != nil {
-			errorMessage := fmt.Errorf("there was an error writing to -> %s:\r\n%s", p.FileLocation, writingErr.Error())
-			agent.Log(errorMessage.Error())
-			return errorMessage
+			agent.Log(writingErr.Error())
+			return false, writingErr
 		}
 		successMessage := fmt.Sprintf("Successfully downloaded file %s with a size of %d bytes from agent %s to %s",
 			p.FileLocation,
@@ -862,9 +2571,491 @@ func fileTransfer(agentID uuid.UUID, p merlinJob.FileTransfer) error {
 	if core.Debug {
 		message("debug", "Leaving agents.FileTransfer")
 	}
+	return true, nil
+}
+
+// exitCode returns the text representation of a command job's exit code, "unknown" if the agent didn't report it, or
+// an empty string if the job didn't run a command
+func exitCode(jobType int, result merlinJob.Results) string {
+	if jobType != merlinJob.CMD {
+		return ""
+	}
+	if !result.HasExitCode {
+		return "unknown"
+	}
+	return strconv.Itoa(result.ExitCode)
+}
+
+// truncate shortens the output to the limit and appends a marker so that it is clear the output is incomplete. The
+// output is cut at the start of a UTF-8 character so that a multibyte character isn't split
+func truncate(output string, limit int) string {
+	if limit == 0 || len(output) <= limit {
+		return output
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + fmt.Sprintf("\r\n[!] Output truncated to %d bytes", limit)
+}
+
+// MaxReadMemory is the largest number of bytes a single ReadMemory job can read from a process
+const MaxReadMemory = 104857600
+
+// checkProcessFilter validates the process name filter for a ps job
+func checkProcessFilter(filter string) error {
+	if strings.TrimSpace(filter) == "" {
+		return fmt.Errorf("the ps process name filter can not be empty")
+	}
+	if len(filter) > 255 {
+		return fmt.Errorf("the ps process name filter is %d characters, the maximum is 255", len(filter))
+	}
+	if strings.ContainsAny(filter, "/\\") {
+		return fmt.Errorf("the ps process name filter %q must be a process name, not a path", filter)
+	}
+	for _, r := range filter {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("the ps process name filter %q contains a control character", filter)
+		}
+	}
+	return nil
+}
+
+// isWhoami returns true when the job queries the agent's current security context
+func isWhoami(jobID string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	p, ok := Jobs[jobID].Payload.(merlinJob.Command)
+	return ok && p.Command == "whoami"
+}
+
+// whoamiInfo returns the agent's current information with the user from the results of a whoami job so that only the
+// user is changed when the agent's information is updated
+func whoamiInfo(agent *agents.Agent, result merlinJob.Results) messages.AgentInfo {
+	return messages.AgentInfo{
+		Version:       agent.Version,
+		Build:         agent.Build,
+		WaitTime:      agent.WaitTime,
+		PaddingMax:    agent.PaddingMax,
+		MaxRetry:      agent.MaxRetry,
+		FailedCheckin: agent.FailedCheckin,
+		Skew:          agent.Skew,
+		Proto:         agent.Proto,
+		KillDate:      agent.KillDate,
+		JA3:           agent.JA3,
+		SysInfo: messages.SysInfo{
+			Platform:     agent.Platform,
+			Architecture: agent.Architecture,
+			UserName:     result.Identity,
+			UserGUID:     result.UserGUID,
+			HostName:     agent.HostName,
+			Process:      agent.Process,
+			Pid:          agent.Pid,
+			Ips:          agent.Ips,
+		},
+	}
+}
+
+// identityTable returns the user, groups, and privileges from a whoami job as a table
+func identityTable(result merlinJob.Results) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "User\t%s\n", result.Identity)
+	if result.UserGUID != "" {
+		_, _ = fmt.Fprintf(w, "User GUID\t%s\n", result.UserGUID)
+	}
+	for i, group := range result.Groups {
+		if i == 0 {
+			_, _ = fmt.Fprintf(w, "Groups\t%s\n", group)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "\t%s\n", group)
+	}
+	for i, privilege := range result.Privileges {
+		if i == 0 {
+			_, _ = fmt.Fprintf(w, "Privileges\t%s\n", privilege)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "\t%s\n", privilege)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// processTable returns the processes from a ps job as a table with a row for each process
+func processTable(processes []merlinJob.Process) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PID\tPPID\tName\tUser")
+	for _, p := range processes {
+		_, _ = fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", p.PID, p.PPID, p.Name, p.User)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// netstatStates are the connection states a netstat job can be filtered by
+var netstatStates = []string{"CLOSE", "CLOSE_WAIT", "CLOSING", "ESTABLISHED", "FIN_WAIT1", "FIN_WAIT2", "LAST_ACK",
+	"LISTEN", "SYN_RECV", "SYN_SENT", "TIME_WAIT"}
+
+// checkNetstatArgs validates the optional -p protocol and -s state filters of a netstat job and returns the arguments
+// with the protocol in lower case and the state in upper case
+func checkNetstatArgs(jobArgs []string) ([]string, error) {
+	var args []string
+	if len(jobArgs) > 0 && strings.ToLower(jobArgs[0]) == "netstat" {
+		args = append(args, jobArgs[0])
+		jobArgs = jobArgs[1:]
+	}
+	var protocol, state bool
+	for i := 0; i < len(jobArgs); i += 2 {
+		if i+1 >= len(jobArgs) {
+			return nil, fmt.Errorf("the netstat %s filter requires a value", jobArgs[i])
+		}
+		value := jobArgs[i+1]
+		switch jobArgs[i] {
+		case "-p":
+			if protocol {
+				return nil, fmt.Errorf("the netstat -p filter can only be provided once")
+			}
+			value = strings.ToLower(value)
+			if value != "tcp" && value != "udp" {
+				return nil, fmt.Errorf("invalid netstat protocol %s, expected tcp or udp", jobArgs[i+1])
+			}
+			protocol = true
+		case "-s":
+			if state {
+				return nil, fmt.Errorf("the netstat -s filter can only be provided once")
+			}
+			value = strings.ToUpper(value)
+			valid := false
+			for _, s := range netstatStates {
+				if value == s {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return nil, fmt.Errorf("invalid netstat state %s, expected one of: %s", jobArgs[i+1], strings.Join(netstatStates, ", "))
+			}
+			state = true
+		default:
+			return nil, fmt.Errorf("unknown netstat argument %s, expected -p <tcp | udp> or -s <state>", jobArgs[i])
+		}
+		args = append(args, jobArgs[i], value)
+	}
+	return args, nil
+}
+
+// connectionTable returns the network connections from a netstat job as a table with a row for each connection
+func connectionTable(connections []merlinJob.Connection) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Proto\tLocal Address\tRemote Address\tState\tPID")
+	for _, c := range connections {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", c.Protocol, c.LocalAddress, c.RemoteAddress, c.State, c.PID)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// registryHives maps the accepted registry hive names to the abbreviation sent to the agent
+var registryHives = map[string]string{
+	"HKLM":                "HKLM",
+	"HKEY_LOCAL_MACHINE":  "HKLM",
+	"HKCU":                "HKCU",
+	"HKEY_CURRENT_USER":   "HKCU",
+	"HKCR":                "HKCR",
+	"HKEY_CLASSES_ROOT":   "HKCR",
+	"HKU":                 "HKU",
+	"HKEY_USERS":          "HKU",
+	"HKCC":                "HKCC",
+	"HKEY_CURRENT_CONFIG": "HKCC",
+}
+
+// registryTypes are the data types a registry value can be added as
+var registryTypes = []string{"REG_SZ", "REG_EXPAND_SZ", "REG_MULTI_SZ", "REG_DWORD", "REG_QWORD", "REG_BINARY"}
+
+// checkRegistryArgs validates the subcommand, key path, value name, data type, and data of a registry job and returns the
+// arguments with the subcommand in lower case and the key path starting with the hive's abbreviation
+func checkRegistryArgs(jobArgs []string) ([]string, error) {
+	if err := checkArgs("registry", jobArgs, 2); err != nil {
+		return nil, err
+	}
+	subcommand := strings.ToLower(jobArgs[0])
+	if subcommand != "query" && subcommand != "add" && subcommand != "delete" {
+		return nil, fmt.Errorf("unknown registry subcommand %s, expected query, add, or delete", jobArgs[0])
+	}
+
+	// Normalize the key path to use backslashes and the hive's abbreviation
+	path := strings.Trim(strings.ReplaceAll(jobArgs[1], "/", "\\"), "\\")
+	parts := strings.SplitN(path, "\\", 2)
+	hive, ok := registryHives[strings.ToUpper(parts[0])]
+	if !ok {
+		return nil, fmt.Errorf("invalid registry hive %q, expected one of HKLM, HKCU, HKCR, HKU, or HKCC", parts[0])
+	}
+	key := hive
+	if len(parts) > 1 {
+		if strings.Contains(parts[1], "\\\\") {
+			return nil, fmt.Errorf("invalid registry key path %q, it contains an empty key name", jobArgs[1])
+		}
+		key += "\\" + parts[1]
+	}
+	if subcommand != "query" && len(parts) < 2 {
+		return nil, fmt.Errorf("a registry key below the %s hive is required to %s a value", hive, subcommand)
+	}
+	args := []string{subcommand, key}
+
+	switch subcommand {
+	case "query", "delete":
+		if len(jobArgs) > 3 {
+			return nil, fmt.Errorf("the registry %s command takes a key path and an optional value name, received %d arguments", subcommand, len(jobArgs)-1)
+		}
+		if len(jobArgs) == 3 {
+			args = append(args, jobArgs[2])
+		}
+	case "add":
+		if err := checkArgs("registry add", jobArgs, 5); err != nil {
+			return nil, err
+		}
+		dataType := strings.ToUpper(jobArgs[3])
+		data := strings.Join(jobArgs[4:], " ")
+		switch dataType {
+		case "REG_SZ", "REG_EXPAND_SZ", "REG_MULTI_SZ":
+		case "REG_DWORD":
+			if _, err := strconv.ParseUint(data, 0, 32); err != nil {
+				return nil, fmt.Errorf("invalid REG_DWORD data %q: %s", data, err)
+			}
+		case "REG_QWORD":
+			if _, err := strconv.ParseUint(data, 0, 64); err != nil {
+				return nil, fmt.Errorf("invalid REG_QWORD data %q: %s", data, err)
+			}
+		case "REG_BINARY":
+			if _, err := hex.DecodeString(data); err != nil {
+				return nil, fmt.Errorf("invalid REG_BINARY data %q, expected hex: %s", data, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid registry data type %s, expected one of: %s", jobArgs[3], strings.Join(registryTypes, ", "))
+		}
+		args = append(args, jobArgs[2], dataType, data)
+	}
+	return args, nil
+}
+
+// registryTable returns the values from a registry query job as a table with a row for each value
+func registryTable(values []merlinJob.Registry) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Key\tName\tType\tData")
+	for _, v := range values {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Key, v.Name, v.Type, v.Data)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// checkRemove validates the arguments of an rm job and returns them with the RecursiveFlag, if provided, first and the
+// ForceFlag removed. A recursive delete must be confirmed with the ForceFlag
+func checkRemove(jobArgs []string) ([]string, error) {
+	var recursive, force bool
+	args := jobArgs
+	for len(args) > 0 && (args[0] == RecursiveFlag || args[0] == ForceFlag) {
+		if args[0] == RecursiveFlag {
+			recursive = true
+		} else {
+			force = true
+		}
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("expected at least 1 file or directory for the rm command, received 0")
+	}
+	if recursive && !force {
+		return nil, fmt.Errorf("a recursive delete removes everything in a directory and must be confirmed with rm %s %s", RecursiveFlag, ForceFlag)
+	}
+	if recursive {
+		return append([]string{RecursiveFlag}, args...), nil
+	}
+	return append([]string(nil), args...), nil
+}
+
+// checkShellcode validates that the shellcode is non-empty base64 encoded data
+func checkShellcode(b64 string) error {
+	if b64 == "" {
+		return fmt.Errorf("the shellcode is empty")
+	}
+	b, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return fmt.Errorf("the shellcode is not valid base64: %s", err)
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("the shellcode decoded to 0 bytes")
+	}
+	return nil
+}
+
+// checkAddress validates that the address is in host:port form with a port between 1 and 65535. An empty host is allowed
+// so that a listener can bind to all interfaces
+func checkAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q, expected host:port: %s", address, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port %q in address %q, expected a number between 1 and 65535", port, address)
+	}
 	return nil
 }
 
+// parseMemoryRegion validates the process ID, base address, and length of a region of memory to read. The address can be
+// decimal or hex with a 0x prefix. The length must be between 1 and MaxReadMemory and the region can't wrap around the
+// end of the 64-bit address space
+func parseMemoryRegion(pid, address, length string) (uint32, uint64, uint64, error) {
+	p, err := strconv.ParseUint(pid, 10, 32)
+	if err != nil || p == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid process ID %s, it must be a positive 32-bit integer", pid)
+	}
+	a, err := strconv.ParseUint(address, 0, 64)
+	if err != nil || a == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid base address %s, it must be a non-zero 64-bit number (e.g., 0x7ff6a0000000)", address)
+	}
+	l, err := strconv.ParseUint(length, 0, 64)
+	if err != nil || l == 0 || l > MaxReadMemory {
+		return 0, 0, 0, fmt.Errorf("invalid length %s, it must be between 1 and %d bytes", length, MaxReadMemory)
+	}
+	if a+l < a {
+		return 0, 0, 0, fmt.Errorf("the %d bytes at address 0x%x extend past the end of the address space", l, a)
+	}
+	return uint32(p), a, l, nil
+}
+
+// checkArgs returns an error if fewer than the expected number of arguments were provided for the job type
+func checkArgs(jobType string, jobArgs []string, expected int) error {
+	if len(jobArgs) < expected {
+		return fmt.Errorf("expected %d arguments for the %s command, received %d", expected, jobType, len(jobArgs))
+	}
+	return nil
+}
+
+// getChannel returns the Agent's job channel, creating it if it does not already exist
+func getChannel(agentID uuid.UUID) chan merlinJob.Job {
+	mutex.Lock()
+	defer mutex.Unlock()
+	jobChannel, ok := JobsChannel[agentID]
+	if !ok {
+		jobChannel = make(chan merlinJob.Job, queueSize)
+		JobsChannel[agentID] = jobChannel
+	}
+	return jobChannel
+}
+
+// newJobID returns a random job ID that is not already used by a job in the Jobs map. The caller must hold mutex so
+// that the ID can't be taken before the job is added
+func newJobID() string {
+	for {
+		id := randJobID()
+		if _, ok := Jobs[id]; !ok {
+			return id
+		}
+	}
+}
+
+// queue adds the job to the Agent's channel by priority, returning an error instead of blocking when the Agent's queue
+// is full
+func queue(agentID uuid.UUID, job merlinJob.Job) error {
+	unlock := lockAgent(agentID)
+	defer unlock()
+
+	jobChannel := getChannel(agentID)
+	mutex.RLock()
+	size := queueSize
+	mutex.RUnlock()
+	if len(jobChannel) >= size || len(jobChannel) >= cap(jobChannel) {
+		return fmt.Errorf("the job queue for agent %s is full with %d jobs", agentID, size)
+	}
+	enqueue(jobChannel, job)
+	return nil
+}
+
+// enqueue adds the job to the channel after every queued job with the same or a higher priority so that Get drains
+// higher priority jobs first and jobs with the same priority in the order they were queued. The caller must hold the
+// Agent's lock and make sure the channel has room for the job
+func enqueue(jobChannel chan merlinJob.Job, job merlinJob.Job) {
+	jobLength := len(jobChannel)
+	queued := make([]merlinJob.Job, 0, jobLength)
+	for i := 0; i < jobLength; i++ {
+		queued = append(queued, <-jobChannel)
+	}
+
+	mutex.RLock()
+	priority := Jobs[job.ID].Priority
+	position := len(queued)
+	for i, q := range queued {
+		if Jobs[q.ID].Priority < priority {
+			position = i
+			break
+		}
+	}
+	mutex.RUnlock()
+
+	for _, q := range queued[:position] {
+		jobChannel <- q
+	}
+	jobChannel <- job
+	for _, q := range queued[position:] {
+		jobChannel <- q
+	}
+}
+
+// discard removes a job that could not be queued from the Jobs map along with its upload source
+func discard(jobID string) {
+	mutex.Lock()
+	delete(Jobs, jobID)
+	mutex.Unlock()
+	transfersMutex.Lock()
+	delete(uploads, jobID)
+	transfersMutex.Unlock()
+}
+
+// filterChannel drains the Agent's job channel, re-queues in order the jobs where keep returns true, and returns the
+// jobs that were removed
+func filterChannel(agentID uuid.UUID, keep func(merlinJob.Job) bool) []merlinJob.Job {
+	unlock := lockAgent(agentID)
+	defer unlock()
+
+	mutex.RLock()
+	jobChannel, ok := JobsChannel[agentID]
+	mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	var removed []merlinJob.Job
+	jobLength := len(jobChannel)
+	for i := 0; i < jobLength; i++ {
+		job := <-jobChannel
+		if keep(job) {
+			jobChannel <- job
+		} else {
+			removed = append(removed, job)
+		}
+	}
+	return removed
+}
+
+// lockAgent acquires the lock for the Agent's job channel and returns the function used to release it
+func lockAgent(agentID uuid.UUID) func() {
+	mutex.Lock()
+	m, ok := agentMutex[agentID]
+	if !ok {
+		m = &sync.Mutex{}
+		agentMutex[agentID] = m
+	}
+	mutex.Unlock()
+	m.Lock()
+	return m.Unlock
+}
+
 // message is used to send send messages to STDOUT where the server is running and not intended to be sent to CLI
 func message(level string, message string) {
 	switch level {
@@ -0,0 +1,89 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"strings"
+	"sync/atomic"
+
+	// Internal
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// Counters is a snapshot of the job counters since the server started, used to monitor the server
+type Counters struct {
+	Created          uint64 // The number of jobs created, including resent and reassigned jobs
+	Sent             uint64 // The number of jobs sent to an agent
+	Completed        uint64 // The number of jobs an agent completed
+	Canceled         uint64 // The number of jobs canceled before they were sent
+	Expired          uint64 // The number of jobs that expired before they completed
+	TimedOut         uint64 // The number of sent jobs that an agent didn't answer before they timed out
+	BytesTransferred uint64 // The number of file bytes uploaded to and downloaded from agents
+	QueueDepth       int    // The number of jobs waiting to be sent, summed across all agents
+}
+
+// counters holds the job counters that Metrics returns. Its fields are only accessed with atomic operations
+var counters Counters
+
+// Metrics returns a snapshot of the job counters and the current number of jobs waiting to be sent
+func Metrics() Counters {
+	m := Counters{
+		Created:          atomic.LoadUint64(&counters.Created),
+		Sent:             atomic.LoadUint64(&counters.Sent),
+		Completed:        atomic.LoadUint64(&counters.Completed),
+		Canceled:         atomic.LoadUint64(&counters.Canceled),
+		Expired:          atomic.LoadUint64(&counters.Expired),
+		TimedOut:         atomic.LoadUint64(&counters.TimedOut),
+		BytesTransferred: atomic.LoadUint64(&counters.BytesTransferred),
+	}
+	mutex.RLock()
+	for _, jobChannel := range JobsChannel {
+		m.QueueDepth += len(jobChannel)
+	}
+	mutex.RUnlock()
+	return m
+}
+
+// countStatus increments the counter for a job that changed to the status
+func countStatus(status int) {
+	switch status {
+	case merlinJob.CREATED:
+		atomic.AddUint64(&counters.Created, 1)
+	case merlinJob.SENT:
+		atomic.AddUint64(&counters.Sent, 1)
+	case merlinJob.COMPLETE:
+		atomic.AddUint64(&counters.Completed, 1)
+	case merlinJob.CANCELED:
+		atomic.AddUint64(&counters.Canceled, 1)
+	case merlinJob.EXPIRED:
+		atomic.AddUint64(&counters.Expired, 1)
+	case merlinJob.TIMEOUT:
+		atomic.AddUint64(&counters.TimedOut, 1)
+	}
+}
+
+// blobSize returns the number of bytes in a base64 encoded file blob without decoding it
+func blobSize(blob string) int {
+	return len(blob)/4*3 - (len(blob) - len(strings.TrimRight(blob, "=")))
+}
+
+// countBytes adds the size of a file, or chunk of a file, sent to or received from an agent to the bytes transferred
+func countBytes(n int) {
+	atomic.AddUint64(&counters.BytesTransferred, uint64(n))
+}
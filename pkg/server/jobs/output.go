@@ -0,0 +1,134 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// JSONOutput is true when job results are written as newline-delimited JSON to the writer set with SetJSONOutput
+var JSONOutput bool
+
+// jsonWriter is where JSON encoded job results are written
+var jsonWriter io.Writer
+
+// jsonMutex guards JSONOutput and jsonWriter so that results from different agents aren't interleaved
+var jsonMutex = &sync.Mutex{}
+
+// callbacks are the functions called every time a job completes
+var callbacks []func(jobID string, i Info, r merlinJob.Results)
+
+// infoCallbacks are the functions called every time an agent reports its information
+var infoCallbacks []func(agentID uuid.UUID, info messages.AgentInfo)
+
+// callbacksMutex guards the callbacks and infoCallbacks slices
+var callbacksMutex = &sync.RWMutex{}
+
+// jsonResult is the structure of a single job result written in JSON output mode
+type jsonResult struct {
+	JobID   string    `json:"job"`
+	AgentID uuid.UUID `json:"agent"`
+	Type    string    `json:"type"`
+	Stdout  string    `json:"stdout"`
+	Stderr  string    `json:"stderr"`
+	Time    time.Time `json:"time"`
+}
+
+// SetJSONOutput enables writing job results as newline-delimited JSON to the provided writer. A nil writer disables it
+func SetJSONOutput(w io.Writer) {
+	jsonMutex.Lock()
+	jsonWriter = w
+	JSONOutput = w != nil
+	jsonMutex.Unlock()
+}
+
+// writeJSON writes the job's results as a single line of JSON when JSON output mode is enabled
+func writeJSON(job merlinJob.Job, result merlinJob.Results) error {
+	jsonMutex.Lock()
+	defer jsonMutex.Unlock()
+	if !JSONOutput || jsonWriter == nil {
+		return nil
+	}
+
+	jobType := merlinJob.String(job.Type)
+	mutex.RLock()
+	j, ok := Jobs[job.ID]
+	mutex.RUnlock()
+	if ok {
+		jobType = j.Type
+	}
+
+	r := jsonResult{
+		JobID:   job.ID,
+		AgentID: job.AgentID,
+		Type:    jobType,
+		Stdout:  result.Stdout,
+		Stderr:  result.Stderr,
+		Time:    time.Now().UTC(),
+	}
+	// json.Encoder terminates each value with a newline
+	err := json.NewEncoder(jsonWriter).Encode(r)
+	if err != nil {
+		return fmt.Errorf("there was an error writing the results for job %s as JSON:\r\n%s", job.ID, err)
+	}
+	return nil
+}
+
+// OnJobComplete registers a function that is called, in its own goroutine, every time a job completes
+func OnJobComplete(fn func(jobID string, i Info, r merlinJob.Results)) {
+	callbacksMutex.Lock()
+	callbacks = append(callbacks, fn)
+	callbacksMutex.Unlock()
+}
+
+// jobComplete calls every registered OnJobComplete function without waiting for them to return
+func jobComplete(jobID string, i Info) {
+	callbacksMutex.RLock()
+	defer callbacksMutex.RUnlock()
+	for _, fn := range callbacks {
+		go fn(jobID, i, i.Result)
+	}
+}
+
+// OnAgentInfo registers a function that is called, in its own goroutine, every time an agent reports its information
+func OnAgentInfo(fn func(agentID uuid.UUID, info messages.AgentInfo)) {
+	callbacksMutex.Lock()
+	infoCallbacks = append(infoCallbacks, fn)
+	callbacksMutex.Unlock()
+}
+
+// agentInfo calls every registered OnAgentInfo function without waiting for them to return
+func agentInfo(agentID uuid.UUID, info messages.AgentInfo) {
+	callbacksMutex.RLock()
+	defer callbacksMutex.RUnlock()
+	for _, fn := range infoCallbacks {
+		go fn(agentID, info)
+	}
+}
@@ -0,0 +1,110 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// paddingPoolSize is the number of random characters in the pool that message padding is copied from
+const paddingPoolSize = 65536
+
+// paddingCharset contains the characters message padding is made of
+var paddingCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// paddingUniform makes the length of message padding uniformly random up to the agent's maximum padding instead of
+// always the maximum
+var paddingUniform bool
+
+// paddingPool contains random characters from the padding charset. It is replaced, never modified, when the charset
+// changes so that padding can be copied from it without holding paddingMutex
+var paddingPool []byte
+
+// paddingRand picks the length of the padding and where in the pool it is copied from
+var paddingRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// paddingMutex guards the padding charset, distribution, pool, and random source
+var paddingMutex = &sync.Mutex{}
+
+// SetPaddingCharset sets the printable ASCII characters that the padding of messages returned to agents is made of
+func SetPaddingCharset(charset string) error {
+	if charset == "" {
+		return fmt.Errorf("the padding charset can not be empty")
+	}
+	for _, r := range charset {
+		if r < 0x20 || r > 0x7e {
+			return fmt.Errorf("the padding charset %q must only contain printable ASCII characters", charset)
+		}
+	}
+	paddingMutex.Lock()
+	paddingCharset = charset
+	paddingPool = nil
+	paddingMutex.Unlock()
+	return nil
+}
+
+// SetPaddingDistribution sets the length of the padding of messages returned to agents: fixed always uses the agent's
+// maximum padding and uniform uses a random length up to the maximum
+func SetPaddingDistribution(distribution string) error {
+	var uniform bool
+	switch strings.ToLower(distribution) {
+	case "fixed":
+		uniform = false
+	case "uniform":
+		uniform = true
+	default:
+		return fmt.Errorf("unknown padding distribution %s, it must be one of: fixed, uniform", distribution)
+	}
+	paddingMutex.Lock()
+	paddingUniform = uniform
+	paddingMutex.Unlock()
+	return nil
+}
+
+// padding returns the padding for a message returned to an agent with a maximum padding of max characters. The
+// padding is copied from a random offset in a pool of random characters so that large padding is cheap to generate
+func padding(max int) string {
+	if max <= 0 {
+		return ""
+	}
+	paddingMutex.Lock()
+	if paddingPool == nil {
+		paddingPool = make([]byte, paddingPoolSize)
+		for i := range paddingPool {
+			paddingPool[i] = paddingCharset[paddingRand.Intn(len(paddingCharset))]
+		}
+	}
+	pool := paddingPool
+	n := max
+	if paddingUniform {
+		n = paddingRand.Intn(max + 1)
+	}
+	offset := paddingRand.Intn(len(pool))
+	paddingMutex.Unlock()
+
+	b := make([]byte, n)
+	for i := 0; i < n; offset = 0 {
+		i += copy(b[i:], pool[offset:])
+	}
+	return string(b)
+}
@@ -0,0 +1,329 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// PersistFile is the default location where jobs are saved so that they survive a server restart
+var PersistFile = filepath.Join(core.CurrentDir, "data", "jobs.gob")
+
+// shuttingDown is true once Shutdown has been called and new jobs are no longer accepted. It is guarded by mutex
+var shuttingDown bool
+
+// saveMutex ensures only one SaveJobs call writes the temporary file at a time
+var saveMutex = &sync.Mutex{}
+
+// state is the structure that is gob encoded to disk to persist jobs between server restarts
+type state struct {
+	Jobs       map[string]info               // A copy of the Jobs map
+	Queued     map[uuid.UUID][]merlinJob.Job // Jobs that were created but not yet sent to the agent
+	Uploads    map[string]upload             // The source file for every chunked upload
+	Secret     []byte                        // The secret that signed the saved jobs' tokens
+	Dependents map[string][]merlinJob.Job    // Jobs being held until the job they depend on completes
+}
+
+// SaveJobs gob encodes the Jobs map, and any jobs waiting in an Agent's channel, to the file at the provided path
+func SaveJobs(path string) error {
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+	s := state{
+		Jobs:       make(map[string]info),
+		Queued:     make(map[uuid.UUID][]merlinJob.Job),
+		Uploads:    make(map[string]upload),
+		Dependents: make(map[string][]merlinJob.Job),
+	}
+
+	mutex.RLock()
+	var agentIDs []uuid.UUID
+	for agentID := range JobsChannel {
+		agentIDs = append(agentIDs, agentID)
+	}
+	mutex.RUnlock()
+
+	// Copy the queued jobs by draining and refilling each channel while holding the Agent's lock
+	for _, agentID := range agentIDs {
+		unlock := lockAgent(agentID)
+		mutex.RLock()
+		jobChannel := JobsChannel[agentID]
+		mutex.RUnlock()
+		jobLength := len(jobChannel)
+		for i := 0; i < jobLength; i++ {
+			job := <-jobChannel
+			s.Queued[agentID] = append(s.Queued[agentID], job)
+			jobChannel <- job
+		}
+		unlock()
+	}
+
+	mutex.RLock()
+	for id, j := range Jobs {
+		s.Jobs[id] = j
+	}
+	mutex.RUnlock()
+
+	transfersMutex.Lock()
+	for id, u := range uploads {
+		s.Uploads[id] = u
+	}
+	transfersMutex.Unlock()
+
+	dependentsMutex.Lock()
+	for id, held := range dependents {
+		s.Dependents[id] = append([]merlinJob.Job(nil), held...)
+	}
+	dependentsMutex.Unlock()
+
+	// Jobs that were added or removed while the state was copied are dropped so that LoadJobs can validate the file
+	for agentID, queued := range s.Queued {
+		s.Queued[agentID] = s.saved(queued)
+	}
+	for id := range s.Uploads {
+		if _, ok := s.Jobs[id]; !ok {
+			delete(s.Uploads, id)
+		}
+	}
+	for id, held := range s.Dependents {
+		if _, ok := s.Jobs[id]; !ok {
+			delete(s.Dependents, id)
+			continue
+		}
+		s.Dependents[id] = s.saved(held)
+	}
+
+	secret, err := getTokenSecret()
+	if err != nil {
+		return err
+	}
+	s.Secret = secret
+
+	// Write to a temporary file first so that a failure doesn't corrupt the previously saved file
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(filepath.Clean(tmp), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("there was an error opening %s to save jobs:\r\n%s", tmp, err)
+	}
+	err = gob.NewEncoder(f).Encode(s)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("there was an error encoding jobs to %s:\r\n%s", tmp, err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("there was an error closing %s:\r\n%s", tmp, err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("there was an error renaming %s to %s:\r\n%s", tmp, path, err)
+	}
+	return nil
+}
+
+// Shutdown stops new jobs from being created, cancels file operations in progress, and saves the Jobs map, and any
+// jobs waiting in an Agent's channel, to PersistFile so that LoadJobs can restore them. It returns when the jobs are
+// saved or the context is done
+func Shutdown(ctx context.Context) error {
+	mutex.Lock()
+	shuttingDown = true
+	mutex.Unlock()
+	cancelOperations()
+
+	path := PersistFile
+	done := make(chan error, 1)
+	go func() {
+		done <- SaveJobs(path)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("the jobs were not saved to %s before shutting down:\r\n%s", path, ctx.Err())
+	}
+}
+
+// LoadJobs decodes jobs previously saved with SaveJobs and adds them to the Jobs map and each Agent's channel.
+// The whole file is decoded and validated before anything is restored so that a bad file never leaves a partially
+// loaded server. A missing file is not an error so that the first run of the server succeeds, and a corrupt file only
+// logs a warning so that the server starts without the saved jobs
+func LoadJobs(path string) error {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("there was an error opening the saved jobs file %s:\r\n%s", path, err)
+	}
+	defer f.Close()
+
+	var s state
+	err = gob.NewDecoder(f).Decode(&s)
+	if err == nil {
+		err = s.validate()
+	}
+	if err != nil {
+		message("warn", fmt.Sprintf("the saved jobs file %s is corrupt and was not loaded, starting without saved jobs:\r\n%s", path, err))
+		return nil
+	}
+
+	// Jobs held on a job that already completed are queued instead
+	queued := make(map[uuid.UUID][]merlinJob.Job)
+	for agentID, jobs := range s.Queued {
+		queued[agentID] = append(queued[agentID], jobs...)
+	}
+	held := make(map[string][]merlinJob.Job)
+	for id, jobs := range s.Dependents {
+		if s.Jobs[id].Status == merlinJob.COMPLETE {
+			for _, job := range jobs {
+				queued[job.AgentID] = append(queued[job.AgentID], job)
+			}
+			continue
+		}
+		held[id] = jobs
+	}
+
+	// Lock every Agent with saved jobs so that the Jobs map and their channels are restored all at once
+	var unlocks []func()
+	for agentID := range queued {
+		unlocks = append(unlocks, lockAgent(agentID))
+	}
+	unlockAll := func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+	mutex.Lock()
+	channels := make(map[uuid.UUID]chan merlinJob.Job)
+	for agentID, jobs := range queued {
+		jobChannel, ok := JobsChannel[agentID]
+		if !ok {
+			jobChannel = make(chan merlinJob.Job, queueSize)
+		}
+		if len(jobs)+len(jobChannel) > queueSize || len(jobs)+len(jobChannel) > cap(jobChannel) {
+			mutex.Unlock()
+			unlockAll()
+			return fmt.Errorf("the saved jobs file %s has more queued jobs for agent %s than the queue size of %d", path, agentID, queueSize)
+		}
+		channels[agentID] = jobChannel
+	}
+
+	if len(s.Secret) > 0 {
+		err = SetTokenSecret(s.Secret)
+		if err != nil {
+			mutex.Unlock()
+			unlockAll()
+			return err
+		}
+	}
+	for id, j := range s.Jobs {
+		Jobs[id] = j
+	}
+	// The saved jobs are queued ahead of any jobs added since the server started
+	for agentID, jobChannel := range channels {
+		jobLength := len(jobChannel)
+		existing := make([]merlinJob.Job, 0, jobLength)
+		for i := 0; i < jobLength; i++ {
+			existing = append(existing, <-jobChannel)
+		}
+		for _, job := range queued[agentID] {
+			jobChannel <- job
+		}
+		for _, job := range existing {
+			jobChannel <- job
+		}
+		JobsChannel[agentID] = jobChannel
+	}
+	mutex.Unlock()
+	unlockAll()
+
+	transfersMutex.Lock()
+	for id, u := range s.Uploads {
+		uploads[id] = u
+	}
+	transfersMutex.Unlock()
+
+	dependentsMutex.Lock()
+	for id, jobs := range held {
+		dependents[id] = append(dependents[id], jobs...)
+	}
+	dependentsMutex.Unlock()
+	return nil
+}
+
+// saved returns the jobs that are in the state's copy of the Jobs map
+func (s state) saved(jobs []merlinJob.Job) []merlinJob.Job {
+	var kept []merlinJob.Job
+	for _, job := range jobs {
+		if _, ok := s.Jobs[job.ID]; ok {
+			kept = append(kept, job)
+		}
+	}
+	return kept
+}
+
+// validate returns an error if the decoded state refers to jobs that are not in it or can't be restored
+func (s state) validate() error {
+	if len(s.Secret) > 0 && len(s.Secret) < 32 {
+		return fmt.Errorf("the job token secret must be at least 32 bytes, received %d", len(s.Secret))
+	}
+	for agentID, queued := range s.Queued {
+		for _, job := range queued {
+			j, ok := s.Jobs[job.ID]
+			if !ok {
+				return fmt.Errorf("queued job %s does not exist", job.ID)
+			}
+			if !uuid.Equal(job.AgentID, agentID) || !uuid.Equal(j.AgentID, agentID) {
+				return fmt.Errorf("queued job %s does not belong to agent %s", job.ID, agentID)
+			}
+		}
+	}
+	for id, held := range s.Dependents {
+		j, ok := s.Jobs[id]
+		if !ok {
+			return fmt.Errorf("job %s that other jobs depend on does not exist", id)
+		}
+		switch j.Status {
+		case merlinJob.CANCELED, merlinJob.EXPIRED, merlinJob.TIMEOUT:
+			return fmt.Errorf("job %s can not be depended on because its status is %s", id, StatusString(j.Status))
+		}
+		for _, job := range held {
+			if _, ok = s.Jobs[job.ID]; !ok {
+				return fmt.Errorf("job %s that depends on job %s does not exist", job.ID, id)
+			}
+		}
+	}
+	for id := range s.Uploads {
+		if _, ok := s.Jobs[id]; !ok {
+			return fmt.Errorf("the upload for job %s does not have a job", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// playbookJob is a single job in a JSON playbook, created the same way as a call to Add
+type playbookJob struct {
+	Type string   `json:"type"` // The job type such as run or upload
+	Args []string `json:"args"` // The job's arguments
+}
+
+// AddFromJSON decodes a JSON array of jobs, each with a type and its arguments, and creates each job for the Agent in
+// order. If a job can't be created the remaining jobs are not created and the IDs of the jobs that were created are
+// returned with the error
+func AddFromJSON(agentID uuid.UUID, r io.Reader) ([]string, error) {
+	var playbook []playbookJob
+	err := json.NewDecoder(r).Decode(&playbook)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error decoding the JSON playbook:\r\n%s", err)
+	}
+
+	var jobIDs []string
+	for i, j := range playbook {
+		jobID, errAdd := Add(agentID, j.Type, j.Args)
+		if errAdd != nil {
+			created := "no jobs were created"
+			if len(jobIDs) > 0 {
+				created = fmt.Sprintf("jobs %s were created", strings.Join(jobIDs, ", "))
+			}
+			return jobIDs, fmt.Errorf("job %d of %d in the playbook, %s, was not created and %s:\r\n%s", i+1, len(playbook), j.Type, created, errAdd)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	return jobIDs, nil
+}
+
+// ExportJSON writes the Agent's active jobs, in the order they were created, as a JSON playbook that AddFromJSON can
+// create again
+func ExportJSON(agentID uuid.UUID, w io.Writer) error {
+	var active []entry
+	mutex.RLock()
+	for id, j := range Jobs {
+		if uuid.Equal(j.AgentID, agentID) && hasStatus(j.Status, []int{merlinJob.CREATED, merlinJob.SENT, merlinJob.RETURNED}) {
+			active = append(active, entry{id, j})
+		}
+	}
+	mutex.RUnlock()
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].job.Created.Before(active[j].job.Created)
+	})
+
+	playbook := make([]playbookJob, 0, len(active))
+	for _, e := range active {
+		if e.job.Name == "" {
+			return fmt.Errorf("job %s can not be exported because the job type it was created with is unknown", e.id)
+		}
+		playbook = append(playbook, playbookJob{Type: e.job.Name, Args: e.job.Args})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(playbook)
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the jobs for agent %s to JSON:\r\n%s", agentID, err)
+	}
+	return nil
+}
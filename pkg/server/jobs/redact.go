@@ -0,0 +1,155 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"regexp"
+	"sync"
+
+	// Internal
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// redacted replaces every match of a redaction pattern in job results
+const redacted = "[REDACTED]"
+
+// redactions are the compiled patterns that are replaced in job results before they are broadcast, logged, or stored
+var redactions []*regexp.Regexp
+
+// redactionEnabled is false when job results are broadcast, logged, and stored as the agent returned them
+var redactionEnabled = true
+
+// keepUnredacted stores the agent's original results with the job, alongside the redacted results, so that they are
+// saved with the rest of the job's information. It is only ever returned by GetUnredactedResults
+var keepUnredacted bool
+
+// redactionMutex guards the redaction patterns and toggles
+var redactionMutex = &sync.RWMutex{}
+
+// AddRedaction adds a regular expression whose matches in job results are replaced with [REDACTED]
+func AddRedaction(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("the redaction pattern can not be empty")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("there was an error compiling the redaction pattern %q:\r\n%s", pattern, err)
+	}
+	redactionMutex.Lock()
+	defer redactionMutex.Unlock()
+	for _, r := range redactions {
+		if r.String() == pattern {
+			return fmt.Errorf("the redaction pattern %q already exists", pattern)
+		}
+	}
+	redactions = append(redactions, re)
+	return nil
+}
+
+// RemoveRedaction removes a regular expression previously added with AddRedaction
+func RemoveRedaction(pattern string) error {
+	redactionMutex.Lock()
+	defer redactionMutex.Unlock()
+	for i, r := range redactions {
+		if r.String() == pattern {
+			redactions = append(redactions[:i:i], redactions[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("the redaction pattern %q does not exist", pattern)
+}
+
+// Redactions returns the regular expressions that are replaced in job results
+func Redactions() []string {
+	redactionMutex.RLock()
+	defer redactionMutex.RUnlock()
+	var patterns []string
+	for _, r := range redactions {
+		patterns = append(patterns, r.String())
+	}
+	return patterns
+}
+
+// SetRedaction enables or disables replacing the redaction patterns in job results
+func SetRedaction(enabled bool) {
+	redactionMutex.Lock()
+	redactionEnabled = enabled
+	redactionMutex.Unlock()
+}
+
+// SetKeepUnredacted enables or disables storing the agent's original results with the job when they are redacted
+func SetKeepUnredacted(enabled bool) {
+	redactionMutex.Lock()
+	keepUnredacted = enabled
+	redactionMutex.Unlock()
+}
+
+// redact returns the results with every match of the redaction patterns replaced
+func redact(result merlinJob.Results) merlinJob.Results {
+	redactionMutex.RLock()
+	defer redactionMutex.RUnlock()
+	if !redactionEnabled {
+		return result
+	}
+	for _, re := range redactions {
+		result.Stdout = re.ReplaceAllString(result.Stdout, redacted)
+		result.Stderr = re.ReplaceAllString(result.Stderr, redacted)
+	}
+	return result
+}
+
+// storeUnredacted appends the agent's original results to the job's unredacted results when they are kept
+func storeUnredacted(jobID string, result merlinJob.Results) {
+	redactionMutex.RLock()
+	keep := keepUnredacted
+	redactionMutex.RUnlock()
+	if !keep {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	j, ok := Jobs[jobID]
+	if !ok {
+		return
+	}
+	// Copies of the job's information share the pointer, so it is replaced rather than modified
+	var unredacted merlinJob.Results
+	if j.Unredacted != nil {
+		unredacted = *j.Unredacted
+	}
+	unredacted.Stdout = truncate(unredacted.Stdout+result.Stdout, resultLimit)
+	unredacted.Stderr = truncate(unredacted.Stderr+result.Stderr, resultLimit)
+	j.Unredacted = &unredacted
+	Jobs[jobID] = j
+}
+
+// GetUnredactedResults returns the job's original results that were kept while SetKeepUnredacted was enabled
+func GetUnredactedResults(jobID string) (merlinJob.Results, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	j, ok := Jobs[jobID]
+	if !ok {
+		return merlinJob.Results{}, fmt.Errorf("job %s does not exist", jobID)
+	}
+	if j.Unredacted == nil {
+		return merlinJob.Results{}, fmt.Errorf("job %s does not have unredacted results", jobID)
+	}
+	return *j.Unredacted, nil
+}
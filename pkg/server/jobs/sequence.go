@@ -0,0 +1,96 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"sync"
+
+	// Internal
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// sequence holds the sequenced results of a job that arrived before the results in front of them
+type sequence struct {
+	next   int                       // The sequence number of the next results to add to the job
+	final  int                       // The sequence number of the job's last results, zero until they arrive
+	chunks map[int]merlinJob.Results // Results waiting for the ones in front of them keyed by sequence number
+}
+
+// sequences contains the results waiting to be added, in order, to each job keyed by the job's ID
+var sequences = make(map[string]*sequence)
+
+// sequencesMutex guards the sequences map
+var sequencesMutex = &sync.Mutex{}
+
+// sequenceResult adds the sequenced results to the job's waiting results and returns every result that is now in order
+// joined together, and false if none are. The returned results are Partial until the final results and every result
+// in front of them have arrived. Results with a sequence number that was already received are ignored. The job's
+// waiting results are removed when its final results are returned, or when the job is pruned or its Agent is purged
+func sequenceResult(jobID string, result merlinJob.Results) (merlinJob.Results, bool, error) {
+	sequencesMutex.Lock()
+	defer sequencesMutex.Unlock()
+	s, ok := sequences[jobID]
+	if !ok {
+		// Only results for a job that exists are kept so that they are removed along with the job
+		mutex.RLock()
+		_, exists := Jobs[jobID]
+		mutex.RUnlock()
+		if !exists {
+			return merlinJob.Results{}, false, fmt.Errorf("sequenced results were received for job %s that does not exist", jobID)
+		}
+		s = &sequence{next: 1, chunks: make(map[int]merlinJob.Results)}
+		sequences[jobID] = s
+	}
+	if _, received := s.chunks[result.Sequence]; received || result.Sequence < s.next {
+		return merlinJob.Results{Partial: true}, false, nil
+	}
+	if result.Final {
+		s.final = result.Sequence
+	}
+	s.chunks[result.Sequence] = result
+
+	var ready bool
+	var stdout, stderr string
+	var last merlinJob.Results
+	for {
+		chunk, k := s.chunks[s.next]
+		if !k {
+			break
+		}
+		stdout += chunk.Stdout
+		stderr += chunk.Stderr
+		last = chunk
+		ready = true
+		delete(s.chunks, s.next)
+		s.next++
+	}
+	if !ready {
+		return merlinJob.Results{Partial: true}, false, nil
+	}
+
+	// Everything other than the output is taken from the latest results, such as the exit code of the final results
+	last.Stdout, last.Stderr = stdout, stderr
+	last.Sequence, last.Final = 0, false
+	last.Partial = s.final == 0 || s.next <= s.final
+	if !last.Partial {
+		delete(sequences, jobID)
+	}
+	return last, true, nil
+}
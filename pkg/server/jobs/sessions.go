@@ -0,0 +1,127 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+// session is an interactive shell on an agent where each line of input is sent to the agent as its own shell job
+type session struct {
+	AgentID uuid.UUID           // ID of the agent the shell runs on
+	Created time.Time           // Time the session was started
+	Jobs    []string            // IDs of the jobs created for each line of input, in order
+	Output  []merlinJob.Results // Results returned for the session's jobs that have not been read
+}
+
+// sessions contains all of the open interactive shell sessions keyed by their session ID
+var sessions = make(map[string]*session)
+
+// sessionsMutex guards the sessions map and each session in it
+var sessionsMutex = &sync.Mutex{}
+
+// StartShell opens an interactive shell session on the agent and returns the session's ID
+func StartShell(agentID uuid.UUID) (string, error) {
+	if _, ok := agents.Agents[agentID]; !ok {
+		return "", fmt.Errorf("%s is not a valid agent", agentID)
+	}
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	var sessionID string
+	for {
+		sessionID = core.RandStringBytesMaskImprSrc(10)
+		if _, ok := sessions[sessionID]; !ok {
+			break
+		}
+	}
+	sessions[sessionID] = &session{
+		AgentID: agentID,
+		Created: time.Now().UTC(),
+	}
+	return sessionID, nil
+}
+
+// ShellInput queues a line of input for the session's agent as a shell job tagged with the session ID and returns the
+// job's ID
+func ShellInput(sessionID string, line string) (string, error) {
+	sessionsMutex.Lock()
+	s, ok := sessions[sessionID]
+	sessionsMutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("shell session %s does not exist", sessionID)
+	}
+	if strings.TrimSpace(line) == "" {
+		return "", fmt.Errorf("a command must be provided for shell session %s", sessionID)
+	}
+
+	jobID, err := add(s.AgentID, "shell", []string{line}, "", sessionID, PRIORITYNORMAL, 0)
+	if err != nil {
+		return "", err
+	}
+
+	sessionsMutex.Lock()
+	s.Jobs = append(s.Jobs, jobID)
+	sessionsMutex.Unlock()
+	return jobID, nil
+}
+
+// ShellOutput returns, in the order they were received, the results returned for the session's jobs since the last
+// time the output was read
+func ShellOutput(sessionID string) ([]merlinJob.Results, error) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	s, ok := sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("shell session %s does not exist", sessionID)
+	}
+	output := s.Output
+	s.Output = nil
+	return output, nil
+}
+
+// StopShell closes the interactive shell session. Jobs already queued for the session are still sent to the agent
+func StopShell(sessionID string) error {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if _, ok := sessions[sessionID]; !ok {
+		return fmt.Errorf("shell session %s does not exist", sessionID)
+	}
+	delete(sessions, sessionID)
+	return nil
+}
+
+// sessionResult adds the results of a job to the output of the shell session it was created for
+func sessionResult(sessionID string, result merlinJob.Results) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if s, ok := sessions[sessionID]; ok {
+		s.Output = append(s.Output, result)
+	}
+}
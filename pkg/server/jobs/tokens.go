@@ -0,0 +1,89 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+)
+
+// tokenSecret is the server secret used to sign job tokens. It is randomly generated the first time a token is needed
+var tokenSecret []byte
+
+// tokenMutex guards tokenSecret
+var tokenMutex = &sync.RWMutex{}
+
+// SetTokenSecret sets the server secret used to sign job tokens. Tokens signed with the previous secret are rejected
+func SetTokenSecret(secret []byte) error {
+	if len(secret) < 32 {
+		return fmt.Errorf("the job token secret must be at least 32 bytes, received %d", len(secret))
+	}
+	tokenMutex.Lock()
+	tokenSecret = append([]byte(nil), secret...)
+	tokenMutex.Unlock()
+	return nil
+}
+
+// getTokenSecret returns the server secret used to sign job tokens, generating a random one if it hasn't been set
+func getTokenSecret() ([]byte, error) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	if tokenSecret == nil {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error generating the job token secret:\r\n%s", err)
+		}
+		tokenSecret = secret
+	}
+	return tokenSecret, nil
+}
+
+// jobToken returns the job's token, an HMAC-SHA256 of the agent ID, job ID, and job type truncated to the size of a
+// UUID, so that a token captured for one job can't be replayed for a different job or agent
+func jobToken(agentID uuid.UUID, jobID string, jobType int) (uuid.UUID, error) {
+	secret, err := getTokenSecret()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	_, err = mac.Write([]byte(fmt.Sprintf("%s|%s|%d", agentID, jobID, jobType)))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("there was an error signing the token for job %s:\r\n%s", jobID, err)
+	}
+	return uuid.FromBytes(mac.Sum(nil)[:uuid.Size])
+}
+
+// checkToken recomputes the job's token and verifies, in constant time, that it matches the token sent by the agent
+func checkToken(agentID uuid.UUID, jobID string, jobType int, token uuid.UUID) error {
+	expected, err := jobToken(agentID, jobID, jobType)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected.Bytes(), token.Bytes()) {
+		return fmt.Errorf("job %s for agent %s did not contain the correct token", jobID, agentID)
+	}
+	return nil
+}
@@ -0,0 +1,1057 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2021  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package jobs
+
+import (
+	// Standard
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Internal
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	merlinJob "github.com/Ne0nd0g/merlin/pkg/jobs"
+)
+
+const (
+	// BASENAME stores a downloaded file in the Agent's directory using only the remote file's name
+	BASENAME = iota
+	// JOBID prefixes the downloaded file's name with the ID of the job that downloaded it
+	JOBID
+	// TIMESTAMP prefixes the downloaded file's name with the UTC time it was downloaded
+	TIMESTAMP
+	// PATH recreates the remote file's directory structure under the Agent's directory
+	PATH
+)
+
+// naming is the scheme used to name files downloaded from an Agent. BASENAME keeps Merlin's original file names and
+// SetNaming opts in to a scheme that keeps files with the same name from overwriting each other
+var naming = BASENAME
+
+// namingMutex guards the naming scheme and the download directory
+var namingMutex = &sync.RWMutex{}
+
+// downloadDir is the directory where each Agent's downloaded files are written. An empty string uses data/agents in
+// the server's current directory
+var downloadDir string
+
+// uploadChunkSize is the number of bytes in each chunk of a file uploaded to an agent
+var uploadChunkSize int64 = 4194304
+
+// maxUploadSize is the largest file, in bytes, that can be uploaded to an agent. Zero disables the limit
+var maxUploadSize int64 = 2147483648
+
+// uploads contains the source file for every chunked upload keyed by job ID
+var uploads = make(map[string]upload)
+
+// upload tracks the file on the server that a chunked upload to an agent is read from
+type upload struct {
+	Source    string // The file on the server being uploaded
+	Size      int64  // The size of the file when the upload was created
+	ChunkSize int64  // The number of bytes in each chunk
+}
+
+// totalChunks returns the number of chunks needed to send the entire file
+func (u upload) totalChunks() int {
+	return int((u.Size + u.ChunkSize - 1) / u.ChunkSize)
+}
+
+// transfers contains all of the chunked file downloads that are in progress keyed by their transfer ID
+var transfers = make(map[string]*transfer)
+
+// transfersMutex guards the transfers map
+var transfersMutex = &sync.Mutex{}
+
+// writeFile writes a downloaded file to disk. It is a variable so that tests can simulate failed writes
+var writeFile = writeContext
+
+// writeRetries is the number of times a failed write of a downloaded file is retried before it is abandoned
+var writeRetries = 3
+
+// writeDelay is how long to wait before the first retry of a failed write. The delay doubles for each retry after it
+var writeDelay = 500 * time.Millisecond
+
+// writeMutex guards the write retries and delay
+var writeMutex = &sync.RWMutex{}
+
+// ioBlockSize is the number of bytes read or written at a time by file operations that can be canceled
+var ioBlockSize = 1048576
+
+// operations contains the file operations in progress keyed by the ID of their job, or by the source file while an
+// upload's file is read before its job is created
+var operations = make(map[string]*operation)
+
+// operationsMutex guards the file operations in progress
+var operationsMutex = &sync.Mutex{}
+
+// operation is the context shared by every file operation in progress for a job so that they can be canceled together
+type operation struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	refs   int // The number of file operations using the context
+}
+
+// directories contains the local directory every directory download in progress is written to keyed by job ID
+var directories = make(map[string]string)
+
+// transfer tracks a chunked file download from an agent that has not received all of its chunks. Its progress is saved
+// next to the chunks on disk so that the download can be resumed after a server restart
+type transfer struct {
+	sync.Mutex  `json:"-"`
+	ID          string       // ID of the file transfer sent by the agent
+	AgentID     uuid.UUID    // ID of the agent sending the file
+	JobID       string       // ID of the job that requested the download
+	File        string       // The temporary file chunks are reassembled in
+	Destination string       // The location the file is moved to after the last chunk is received
+	Received    int          // The number of chunks received so far
+	TotalChunks int          // The total number of chunks that make up the file
+	Hash        string       // The SHA-256 hash of the entire file sent by the agent
+	Chunks      map[int]bool // The index of every chunk received so far
+}
+
+// progressFile returns the file the transfer's progress is saved to
+func (t *transfer) progressFile() string {
+	return t.File + ".json"
+}
+
+// chunkFile returns the file a chunk of the transfer is written to until the last chunk is received
+func (t *transfer) chunkFile(chunk int) string {
+	return fmt.Sprintf("%s.%d", t.File, chunk)
+}
+
+// nextChunk returns the index of the first chunk that has not been received, or the total number of chunks when
+// every chunk has been received. The caller must hold the transfer's lock
+func (t *transfer) nextChunk() int {
+	for i := 0; i < t.TotalChunks; i++ {
+		if !t.Chunks[i] {
+			return i
+		}
+	}
+	return t.TotalChunks
+}
+
+// save writes the transfer's progress to disk. The caller must hold the transfer's lock
+func (t *transfer) save() error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the progress of file transfer %s:\r\n%s", t.ID, err)
+	}
+	err = ioutil.WriteFile(t.progressFile(), data, 0600)
+	if err != nil {
+		return fmt.Errorf("there was an error saving the progress of file transfer %s to %s:\r\n%s", t.ID, t.progressFile(), err)
+	}
+	return nil
+}
+
+// assemble writes every chunk, in order, to the transfer's file and removes the chunks and saved progress. The partial
+// file is removed if the context is done first, leaving the chunks so that the download can be resumed. The caller must
+// hold the transfer's lock
+func (t *transfer) assemble(ctx context.Context) error {
+	f, err := os.OpenFile(filepath.Clean(t.File), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("there was an error opening %s to reassemble file transfer %s:\r\n%s", t.File, t.ID, err)
+	}
+	for i := 0; i < t.TotalChunks; i++ {
+		chunk, errChunk := os.Open(filepath.Clean(t.chunkFile(i)))
+		if errChunk != nil {
+			_ = f.Close()
+			return fmt.Errorf("there was an error opening chunk %d of file transfer %s:\r\n%s", i, t.ID, errChunk)
+		}
+		_, errChunk = copyContext(ctx, f, chunk)
+		_ = chunk.Close()
+		if errChunk != nil {
+			_ = f.Close()
+			_ = os.Remove(t.File)
+			return fmt.Errorf("there was an error writing chunk %d to %s:\r\n%s", i, t.File, errChunk)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("there was an error closing %s:\r\n%s", t.File, err)
+	}
+	for i := 0; i < t.TotalChunks; i++ {
+		_ = os.Remove(t.chunkFile(i))
+	}
+	_ = os.Remove(t.progressFile())
+	return nil
+}
+
+// loadTransfer searches the agent's download directory for the saved progress of an interrupted transfer where match
+// returns true and tracks it as in progress so that the rest of its chunks can be received
+func loadTransfer(agentID uuid.UUID, match func(*transfer) bool) (*transfer, bool) {
+	var found *transfer
+	agentDir := filepath.Join(getDownloadDir(), agentID.String())
+	_ = filepath.Walk(agentDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || found != nil || fi.IsDir() || !strings.HasSuffix(path, ".part.json") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return nil
+		}
+		t := &transfer{}
+		if json.Unmarshal(data, t) != nil || !uuid.Equal(t.AgentID, agentID) || !match(t) {
+			return nil
+		}
+		if t.Chunks == nil {
+			t.Chunks = make(map[int]bool)
+		}
+		found = t
+		return nil
+	})
+	if found == nil {
+		return nil, false
+	}
+
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+	// The transfer may have been loaded while the directory was searched
+	if t, ok := transfers[found.ID]; ok {
+		return t, true
+	}
+	transfers[found.ID] = found
+	return found, true
+}
+
+// fileOperation returns the context for a file operation for the job and a function that must be called when the
+// operation is done. The context is canceled by cancelOperation or when the server shuts down
+func fileOperation(jobID string) (context.Context, func()) {
+	operationsMutex.Lock()
+	defer operationsMutex.Unlock()
+	op, ok := operations[jobID]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		op = &operation{ctx: ctx, cancel: cancel}
+		operations[jobID] = op
+	}
+	op.refs++
+
+	mutex.RLock()
+	stopped := shuttingDown
+	mutex.RUnlock()
+	if stopped {
+		op.cancel()
+	}
+
+	return op.ctx, func() {
+		operationsMutex.Lock()
+		defer operationsMutex.Unlock()
+		op.refs--
+		if op.refs == 0 {
+			op.cancel()
+			if operations[jobID] == op {
+				delete(operations, jobID)
+			}
+		}
+	}
+}
+
+// cancelOperation cancels the file operations in progress for the job and returns false if there aren't any
+func cancelOperation(jobID string) bool {
+	operationsMutex.Lock()
+	defer operationsMutex.Unlock()
+	op, ok := operations[jobID]
+	if ok {
+		op.cancel()
+	}
+	return ok
+}
+
+// cancelOperations cancels every file operation in progress
+func cancelOperations() {
+	operationsMutex.Lock()
+	defer operationsMutex.Unlock()
+	for _, op := range operations {
+		op.cancel()
+	}
+}
+
+// copyContext copies from src to dst one block at a time and stops with the context's error once it is done
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, ioBlockSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			w, errWrite := dst.Write(buf[:n])
+			written += int64(w)
+			if errWrite != nil {
+				return written, errWrite
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// writeContext writes data to the file like ioutil.WriteFile, but stops and removes the partial file when the context
+// is done before all of the data is written
+func writeContext(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filepath.Clean(filename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = copyContext(ctx, f, bytes.NewReader(data))
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil && ctx.Err() != nil {
+		_ = os.Remove(filename)
+	}
+	return err
+}
+
+// readContext reads the file like ioutil.ReadFile, but stops when the context is done
+func readContext(ctx context.Context, filename string) ([]byte, error) {
+	f, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var data bytes.Buffer
+	if fi, errStat := f.Stat(); errStat == nil {
+		data.Grow(int(fi.Size()))
+	}
+	_, err = copyContext(ctx, &data, f)
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// writeChunk writes one chunk of a file download to disk and reassembles the file in its final location once every
+// chunk is received. Chunks can be received in any order. It returns true when the download is complete
+func writeChunk(ctx context.Context, agent *agents.Agent, jobID string, downloadFile string, p merlinJob.FileTransfer, blob []byte) (bool, error) {
+	id := p.ID
+	if id == "" {
+		id = jobID
+	}
+
+	transfersMutex.Lock()
+	t, ok := transfers[id]
+	transfersMutex.Unlock()
+	if !ok {
+		// A download interrupted by a server restart continues from the progress saved on disk
+		t, ok = loadTransfer(agent.ID, func(l *transfer) bool { return l.ID == id })
+	}
+	if !ok {
+		// The first chunk received starts the transfer, whichever chunk it is
+		if p.TotalChunks <= 0 {
+			errorMessage := fmt.Errorf("received chunk %d of %d for unknown file transfer %s", p.Chunk, p.TotalChunks, id)
+			agent.Log(errorMessage.Error())
+			return false, errorMessage
+		}
+		transfersMutex.Lock()
+		// Another chunk may have started the transfer while the saved progress was searched for
+		t, ok = transfers[id]
+		if !ok {
+			t = &transfer{
+				ID:          id,
+				AgentID:     agent.ID,
+				JobID:       jobID,
+				File:        fmt.Sprintf("%s.%s.part", downloadFile, id),
+				Destination: downloadFile,
+				TotalChunks: p.TotalChunks,
+				Chunks:      make(map[int]bool),
+			}
+			transfers[id] = t
+		}
+		transfersMutex.Unlock()
+	}
+
+	// Lock the transfer, not the whole map, so the disk write doesn't block other transfers
+	t.Lock()
+	defer t.Unlock()
+
+	if p.Chunk < 0 || p.Chunk >= t.TotalChunks {
+		errorMessage := fmt.Errorf("received chunk %d for file transfer %s that only has %d chunks", p.Chunk, id, t.TotalChunks)
+		agent.Log(errorMessage.Error())
+		return false, errorMessage
+	}
+	if t.Chunks[p.Chunk] {
+		errorMessage := fmt.Errorf("already received chunk %d for file transfer %s", p.Chunk, id)
+		agent.Log(errorMessage.Error())
+		return false, errorMessage
+	}
+
+	err := writeContext(ctx, t.chunkFile(p.Chunk), blob, 0600)
+	if err != nil {
+		errorMessage := fmt.Errorf("there was an error writing chunk %d to %s:\r\n%s", p.Chunk, t.chunkFile(p.Chunk), err)
+		agent.Log(errorMessage.Error())
+		return false, errorMessage
+	}
+	t.Chunks[p.Chunk] = true
+	t.Received = len(t.Chunks)
+	if p.Hash != "" {
+		t.Hash = p.Hash
+	}
+	// The chunk is already on disk so a failure to save the progress only prevents resuming after a restart
+	if errSave := t.save(); errSave != nil {
+		message("warn", errSave.Error())
+		agent.Log(errSave.Error())
+	}
+
+	// Update the job's progress so that it is visible in the active jobs table
+	mutex.Lock()
+	j, ok := Jobs[jobID]
+	if ok {
+		j.Chunk = t.Received
+		j.TotalChunks = t.TotalChunks
+		Jobs[jobID] = j
+	}
+	mutex.Unlock()
+
+	if t.Received < t.TotalChunks {
+		agent.Log(fmt.Sprintf("Received chunk %d of %d for file %s", t.Received, t.TotalChunks, p.FileLocation))
+		return false, nil
+	}
+
+	// The last chunk was received
+	transfersMutex.Lock()
+	delete(transfers, id)
+	transfersMutex.Unlock()
+
+	err = t.assemble(ctx)
+	if err != nil {
+		agent.Log(err.Error())
+		return false, err
+	}
+
+	// Verify the file's integrity before moving it to its final location
+	size, hash, err := hashFile(ctx, t.File)
+	if err != nil {
+		agent.Log(err.Error())
+		return false, err
+	}
+	if t.Hash != "" {
+		errHash := checkHash(p.FileLocation, t.Hash, hash)
+		if errHash != nil {
+			agent.Log(errHash.Error())
+			errRemove := os.Remove(t.File)
+			if errRemove != nil {
+				agent.Log(fmt.Sprintf("there was an error removing %s:\r\n%s", t.File, errRemove))
+			}
+			return false, errHash
+		}
+	}
+
+	err = os.Rename(t.File, t.Destination)
+	if err != nil {
+		errorMessage := fmt.Errorf("there was an error moving %s to %s:\r\n%s", t.File, t.Destination, err)
+		agent.Log(errorMessage.Error())
+		return false, errorMessage
+	}
+	successMessage := fmt.Sprintf("Successfully downloaded file %s in %d chunks with a size of %d bytes and SHA-256: %x from agent %s to %s",
+		p.FileLocation,
+		t.TotalChunks,
+		size,
+		hash,
+		agent.ID,
+		t.Destination)
+	message("success", successMessage)
+	agent.Log(successMessage)
+	return true, nil
+}
+
+// DownloadProgress returns the number of chunks received and the total number of chunks for a file being downloaded
+// from an agent. A download that was not sent in chunks returns 1 of 1 once it is complete, and a download that hasn't
+// received its first chunk returns 0 of 0
+func DownloadProgress(jobID string) (received, total int, err error) {
+	transfersMutex.Lock()
+	var t *transfer
+	for _, v := range transfers {
+		if v.JobID == jobID {
+			t = v
+			break
+		}
+	}
+	transfersMutex.Unlock()
+	if t != nil {
+		t.Lock()
+		defer t.Unlock()
+		return t.Received, t.TotalChunks, nil
+	}
+
+	mutex.RLock()
+	j, ok := Jobs[jobID]
+	mutex.RUnlock()
+	if !ok {
+		return 0, 0, fmt.Errorf("job %s does not exist", jobID)
+	}
+	if p, k := j.Payload.(merlinJob.FileTransfer); !k || p.IsDownload {
+		return 0, 0, fmt.Errorf("job %s is not a file download", jobID)
+	}
+	switch j.Status {
+	case merlinJob.CREATED, merlinJob.SENT:
+		return 0, 0, nil
+	case merlinJob.COMPLETE:
+		if j.TotalChunks <= 1 {
+			return 1, 1, nil
+		}
+		return 0, 0, fmt.Errorf("the chunked download for job %s already completed", jobID)
+	default:
+		return 0, 0, fmt.Errorf("the download for job %s is not in progress because its status is %s", jobID, StatusString(j.Status))
+	}
+}
+
+// ResumeDownload returns the first chunk that has not been received for the job's chunked file download so that a
+// re-tasked download can continue from it instead of the first chunk. The progress of a download that was interrupted
+// by a server restart is read from disk
+func ResumeDownload(jobID string) (nextChunk int, err error) {
+	mutex.RLock()
+	j, ok := Jobs[jobID]
+	mutex.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("job %s does not exist", jobID)
+	}
+	if p, k := j.Payload.(merlinJob.FileTransfer); !k || p.IsDownload {
+		return 0, fmt.Errorf("job %s is not a file download", jobID)
+	}
+
+	transfersMutex.Lock()
+	var t *transfer
+	for _, v := range transfers {
+		if v.JobID == jobID {
+			t = v
+			break
+		}
+	}
+	transfersMutex.Unlock()
+	if t == nil {
+		t, ok = loadTransfer(j.AgentID, func(l *transfer) bool { return l.JobID == jobID })
+		if !ok {
+			// No chunks were received
+			return 0, nil
+		}
+	}
+	t.Lock()
+	defer t.Unlock()
+	return t.nextChunk(), nil
+}
+
+// hashFile returns the size and SHA-256 hash of the file at the provided path, stopping when the context is done
+func hashFile(ctx context.Context, path string) (int64, []byte, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return 0, nil, fmt.Errorf("there was an error opening %s to calculate its hash:\r\n%s", path, err)
+	}
+	defer f.Close()
+
+	fileHash := sha256.New()
+	size, err := copyContext(ctx, fileHash, f)
+	if err != nil {
+		return 0, nil, fmt.Errorf("there was an error calculating the hash for %s:\r\n%s", path, err)
+	}
+	return size, fileHash.Sum(nil), nil
+}
+
+// SetWriteRetry sets the number of times a failed write of a downloaded file is retried and the delay before the first
+// retry, which doubles for each retry after it
+func SetWriteRetry(retries int, delay time.Duration) error {
+	if retries < 0 {
+		return fmt.Errorf("the number of write retries can not be negative: %d", retries)
+	}
+	if delay < 0 {
+		return fmt.Errorf("the write retry delay can not be negative: %s", delay)
+	}
+	writeMutex.Lock()
+	writeRetries = retries
+	writeDelay = delay
+	writeMutex.Unlock()
+	return nil
+}
+
+// writeDownload writes a downloaded file to disk, retrying with an exponential backoff if the write fails. If every
+// attempt fails the data is saved to a file in the temporary directory so that it isn't lost. When the context is done
+// the write stops, the partial file is removed, and an error wrapping the context's error is returned
+func writeDownload(ctx context.Context, agent *agents.Agent, downloadFile string, data []byte) error {
+	writeMutex.RLock()
+	retries := writeRetries
+	delay := writeDelay
+	writeMutex.RUnlock()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = writeFile(ctx, downloadFile, data, 0600)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("the write to %s was canceled and the partial file was removed: %w", downloadFile, ctx.Err())
+		}
+		if attempt >= retries {
+			break
+		}
+		agent.Log(fmt.Sprintf("Attempt %d of %d to write %s failed, retrying in %s:\r\n%s", attempt+1, retries+1, downloadFile, delay, err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("the write to %s was canceled: %w", downloadFile, ctx.Err())
+		}
+		delay *= 2
+	}
+
+	errorMessage := fmt.Errorf("there was an error writing to -> %s after %d attempts:\r\n%s", downloadFile, retries+1, err)
+	f, errTemp := ioutil.TempFile("", fmt.Sprintf("merlin_%s_*", filepath.Base(downloadFile)))
+	if errTemp != nil {
+		return fmt.Errorf("%s\r\nthere was an error creating a file to recover the data:\r\n%s", errorMessage, errTemp)
+	}
+	_, errTemp = f.Write(data)
+	if errClose := f.Close(); errTemp == nil {
+		errTemp = errClose
+	}
+	if errTemp != nil {
+		return fmt.Errorf("%s\r\nthere was an error writing the data to %s:\r\n%s", errorMessage, f.Name(), errTemp)
+	}
+	return fmt.Errorf("%s\r\nthe data was saved to %s", errorMessage, f.Name())
+}
+
+// checkCompress returns true if the optional argument after a file transfer's file names is "compress"
+func checkCompress(jobType string, jobArgs []string) (bool, error) {
+	if len(jobArgs) == 0 {
+		return false, nil
+	}
+	if len(jobArgs) > 1 || strings.ToLower(jobArgs[0]) != "compress" {
+		return false, fmt.Errorf("unknown %s argument %s, expected compress", jobType, strings.Join(jobArgs, " "))
+	}
+	return true, nil
+}
+
+// compress gzip compresses the data of a file transfer
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error compressing the file transfer:\r\n%s", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("there was an error compressing the file transfer:\r\n%s", err)
+	}
+	return b.Bytes(), nil
+}
+
+// decompress returns the original data of a gzip compressed file transfer
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error decompressing the file transfer:\r\n%s", err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error decompressing the file transfer:\r\n%s", err)
+	}
+	return b, nil
+}
+
+// checkHash compares the SHA-256 hash calculated by the server to the hex encoded hash provided by the agent
+func checkHash(file string, expected string, computed []byte) error {
+	if !strings.EqualFold(expected, hex.EncodeToString(computed)) {
+		return fmt.Errorf("the SHA-256 hash for %s did not match and the file was not saved.\r\nExpected: %s, Computed: %x",
+			file, expected, computed)
+	}
+	return nil
+}
+
+// SetNaming sets the scheme used to name files downloaded from an Agent: basename, jobid, timestamp, or path
+func SetNaming(scheme string) error {
+	var n int
+	switch strings.ToLower(scheme) {
+	case "basename":
+		n = BASENAME
+	case "jobid":
+		n = JOBID
+	case "timestamp":
+		n = TIMESTAMP
+	case "path":
+		n = PATH
+	default:
+		return fmt.Errorf("unknown download naming scheme %s, it must be one of: basename, jobid, timestamp, path", scheme)
+	}
+	namingMutex.Lock()
+	naming = n
+	namingMutex.Unlock()
+	return nil
+}
+
+// SetDownloadDir sets the directory where a subdirectory for each Agent's downloaded files is created. The directory
+// is created if it doesn't exist. An empty path uses the default data/agents directory
+func SetDownloadDir(dir string) error {
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("there was an error getting the absolute path of %s:\r\n%s", dir, err)
+		}
+		err = os.MkdirAll(abs, 0750)
+		if err != nil {
+			return fmt.Errorf("there was an error creating the download directory %s:\r\n%s", abs, err)
+		}
+		dir = abs
+	}
+	namingMutex.Lock()
+	downloadDir = dir
+	namingMutex.Unlock()
+	return nil
+}
+
+// getDownloadDir returns the directory where a subdirectory for each Agent's downloaded files is created
+func getDownloadDir() string {
+	namingMutex.RLock()
+	defer namingMutex.RUnlock()
+	if downloadDir == "" {
+		return filepath.Join(core.CurrentDir, "data", "agents")
+	}
+	return downloadDir
+}
+
+// downloadPath returns the location in the Agent's directory where a file downloaded by the job will be written,
+// according to the naming scheme, creating any missing directories
+func downloadPath(agentDir string, jobID string, fileLocation string) (string, error) {
+	// Agents can be on any OS so normalize the remote path to forward slashes
+	remote := strings.ReplaceAll(fileLocation, "\\", "/")
+	name := path.Base(remote)
+
+	namingMutex.RLock()
+	scheme := naming
+	namingMutex.RUnlock()
+
+	var downloadFile string
+	switch scheme {
+	case JOBID:
+		downloadFile = filepath.Join(agentDir, fmt.Sprintf("%s_%s", jobID, name))
+	case TIMESTAMP:
+		downloadFile = filepath.Join(agentDir, fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102T150405Z"), name))
+	case PATH:
+		// Drop the Windows volume name and any relative elements so the file stays in the Agent's directory
+		if len(remote) > 1 && remote[1] == ':' {
+			remote = remote[2:]
+		}
+		downloadFile = filepath.Join(agentDir, filepath.FromSlash(path.Clean("/"+remote)))
+	default:
+		downloadFile = filepath.Join(agentDir, name)
+	}
+
+	rel, err := filepath.Rel(agentDir, downloadFile)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("the download location for %s is outside of the agent's directory %s", fileLocation, agentDir)
+	}
+
+	err = os.MkdirAll(filepath.Dir(downloadFile), 0750)
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating the directory for %s:\r\n%s", downloadFile, err)
+	}
+	return downloadFile, nil
+}
+
+// checkRemoteDir validates the directory on the agent for a directory download
+func checkRemoteDir(dir string) error {
+	if strings.TrimSpace(dir) == "" {
+		return fmt.Errorf("the directory to download can not be empty")
+	}
+	for _, r := range dir {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("the directory to download %q contains a control character", dir)
+		}
+	}
+	return nil
+}
+
+// checkDestination validates the location on the agent a file is uploaded to and returns true if it is a relative path.
+// A destination that contains path traversal is only allowed when forced
+func checkDestination(destination string, force bool) (bool, error) {
+	if strings.TrimSpace(destination) == "" {
+		return false, fmt.Errorf("the upload destination can not be empty")
+	}
+	// Agents can be on any OS so check both path separators
+	remote := strings.ReplaceAll(destination, "\\", "/")
+	if !force {
+		for _, element := range strings.Split(remote, "/") {
+			if element == ".." {
+				return false, fmt.Errorf("the upload destination %s contains path traversal, use upload %s to upload it anyway", destination, ForceFlag)
+			}
+		}
+	}
+	absolute := strings.HasPrefix(remote, "/") || (len(remote) > 2 && remote[1] == ':' && remote[2] == '/')
+	return !absolute, nil
+}
+
+// directoryRoot returns the directory on the agent that the job downloads and true if the job is a directory download
+func directoryRoot(jobID string) (string, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	p, ok := Jobs[jobID].Payload.(merlinJob.FileTransfer)
+	if !ok || !p.Directory {
+		return "", false
+	}
+	return p.FileLocation, true
+}
+
+// directoryPath returns the location a file from a directory download is written to, recreating the file's path
+// relative to the downloaded directory under the directory's location in the Agent's directory
+func directoryPath(agentDir string, jobID string, root string, fileLocation string) (string, error) {
+	// Agents can be on any OS so normalize both paths to forward slashes
+	r := path.Clean(strings.ReplaceAll(root, "\\", "/"))
+	f := path.Clean(strings.ReplaceAll(fileLocation, "\\", "/"))
+	var rel string
+	if !strings.EqualFold(r, f) {
+		prefix := strings.TrimSuffix(r, "/") + "/"
+		if len(f) <= len(prefix) || !strings.EqualFold(f[:len(prefix)], prefix) {
+			return "", fmt.Errorf("the file %s is not in the downloaded directory %s", fileLocation, root)
+		}
+		rel = f[len(prefix):]
+	}
+
+	// Every file must be written to the same directory even if the naming scheme changes while it is downloaded
+	transfersMutex.Lock()
+	dir, ok := directories[jobID]
+	transfersMutex.Unlock()
+	if !ok {
+		var err error
+		dir, err = downloadPath(agentDir, jobID, r)
+		if err != nil {
+			return "", err
+		}
+		transfersMutex.Lock()
+		directories[jobID] = dir
+		transfersMutex.Unlock()
+	}
+
+	downloadFile := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+rel)))
+	check, err := filepath.Rel(dir, downloadFile)
+	if err != nil || strings.HasPrefix(check, "..") {
+		return "", fmt.Errorf("the download location for %s is outside of the directory %s", fileLocation, dir)
+	}
+	err = os.MkdirAll(filepath.Dir(downloadFile), 0750)
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating the directory for %s:\r\n%s", downloadFile, err)
+	}
+	return downloadFile, nil
+}
+
+// directoryReceived counts a file received for a directory download and returns true once every file in the directory
+// has been received. Jobs that are not directory downloads are complete after their one file is received
+func directoryReceived(jobID string, p merlinJob.FileTransfer) bool {
+	mutex.Lock()
+	j, ok := Jobs[jobID]
+	payload, k := j.Payload.(merlinJob.FileTransfer)
+	if !ok || !k || !payload.Directory {
+		mutex.Unlock()
+		return true
+	}
+	if p.Files > 0 {
+		j.Files++
+	}
+	j.TotalFiles = p.Files
+	// The chunk progress is for the file that was just received
+	j.Chunk = 0
+	j.TotalChunks = 0
+	Jobs[jobID] = j
+	mutex.Unlock()
+
+	if j.Files < j.TotalFiles {
+		return false
+	}
+	transfersMutex.Lock()
+	delete(directories, jobID)
+	transfersMutex.Unlock()
+	return true
+}
+
+// isScreenshot returns true when the job is a screenshot whose image is returned as a file transfer
+func isScreenshot(jobID string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	p, ok := Jobs[jobID].Payload.(merlinJob.Command)
+	return ok && p.Command == "screenshot"
+}
+
+// screenshotPath returns the timestamped location in the agent's screenshots directory where a screenshot is written.
+// The image must be a PNG or JPEG with a SHA-256 hash so that it can be verified after it is base64 decoded
+func screenshotPath(agentDir string, jobID string, p merlinJob.FileTransfer, blob []byte) (string, error) {
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(p.FileLocation, "\\", "/")))
+	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+		return "", fmt.Errorf("the screenshot for job %s is not a PNG or JPEG file: %s", jobID, p.FileLocation)
+	}
+	// Only the first chunk of a chunked screenshot contains the image's header
+	if p.Chunk == 0 {
+		png := bytes.HasPrefix(blob, []byte("\x89PNG\r\n\x1a\n"))
+		jpeg := bytes.HasPrefix(blob, []byte{0xff, 0xd8, 0xff})
+		if (ext == ".png" && !png) || (ext != ".png" && !jpeg) {
+			return "", fmt.Errorf("the screenshot for job %s does not contain %s image data", jobID, ext)
+		}
+	}
+
+	// Chunks after the first must be written to the same file so the timestamp comes from the in progress transfer
+	id := p.ID
+	if id == "" {
+		id = jobID
+	}
+	transfersMutex.Lock()
+	t, ok := transfers[id]
+	transfersMutex.Unlock()
+	var hash string
+	if ok {
+		t.Lock()
+		hash = t.Hash
+		t.Unlock()
+	}
+
+	// The hash is only required with the last chunk because it isn't known until the whole image is read
+	if p.Hash == "" && hash == "" && p.Chunk >= p.TotalChunks-1 {
+		return "", fmt.Errorf("the screenshot for job %s did not include a SHA-256 hash", jobID)
+	}
+	if ok {
+		return t.Destination, nil
+	}
+
+	dir := filepath.Join(agentDir, "screenshots")
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating the screenshots directory %s:\r\n%s", dir, err)
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s%s", time.Now().UTC().Format("20060102T150405Z"), jobID, ext)), nil
+}
+
+// SetUploadChunkSize sets the number of bytes in each chunk of a file uploaded to an agent
+func SetUploadChunkSize(size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("the upload chunk size must be greater than 0: %d", size)
+	}
+	transfersMutex.Lock()
+	uploadChunkSize = size
+	transfersMutex.Unlock()
+	return nil
+}
+
+// SetMaxUploadSize sets the largest file, in bytes, that can be uploaded to an agent. Zero disables the limit
+func SetMaxUploadSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("the maximum upload size can not be negative: %d", size)
+	}
+	transfersMutex.Lock()
+	maxUploadSize = size
+	transfersMutex.Unlock()
+	return nil
+}
+
+// checkUploadSize returns an error if the file is larger than the maximum upload size
+func checkUploadSize(file string, size int64) error {
+	transfersMutex.Lock()
+	max := maxUploadSize
+	transfersMutex.Unlock()
+	if max > 0 && size > max {
+		return fmt.Errorf("the file %s is %d bytes, which is larger than the maximum upload size of %d bytes", file, size, max)
+	}
+	return nil
+}
+
+// getUploadChunkSize returns the number of bytes in each chunk of a file uploaded to an agent
+func getUploadChunkSize() int64 {
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+	return uploadChunkSize
+}
+
+// setUpload records the source file for a chunked upload job
+func setUpload(jobID string, u upload) {
+	transfersMutex.Lock()
+	uploads[jobID] = u
+	transfersMutex.Unlock()
+}
+
+// deleteUpload removes the source file of a chunked upload once the agent has received every chunk. The source of an
+// upload that didn't complete is kept so that it can be resent, until the job is pruned
+func deleteUpload(jobID string) {
+	transfersMutex.Lock()
+	delete(uploads, jobID)
+	transfersMutex.Unlock()
+}
+
+// fillChunk reads the job's chunk of a chunked upload from disk into its payload and returns the job for the next
+// chunk, if there is one. Jobs that are not chunked uploads are not modified
+func fillChunk(job *merlinJob.Job) (*merlinJob.Job, error) {
+	p, ok := job.Payload.(merlinJob.FileTransfer)
+	if !ok || !p.IsDownload || p.TotalChunks <= 1 || p.FileBlob != "" {
+		return nil, nil
+	}
+
+	transfersMutex.Lock()
+	u, ok := uploads[job.ID]
+	transfersMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("the source file for chunked upload job %s is unknown", job.ID)
+	}
+
+	f, err := os.Open(filepath.Clean(u.Source))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error opening %s to read chunk %d for job %s:\r\n%s", u.Source, p.Chunk, job.ID, err)
+	}
+	defer f.Close()
+
+	chunk := make([]byte, u.ChunkSize)
+	n, err := f.ReadAt(chunk, int64(p.Chunk)*u.ChunkSize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("there was an error reading chunk %d of %s for job %s:\r\n%s", p.Chunk, u.Source, job.ID, err)
+	}
+
+	next := *job
+	blob := chunk[:n]
+	if p.Compressed {
+		blob, err = compress(blob)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.FileBlob = base64.StdEncoding.EncodeToString(blob)
+	job.Payload = p
+
+	if p.Chunk+1 >= p.TotalChunks {
+		return nil, nil
+	}
+	np := p
+	np.FileBlob = ""
+	np.Chunk++
+	next.Payload = np
+	return &next, nil
+}